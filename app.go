@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"time"
 	electron "./electronbridge_golib"
 )
@@ -15,6 +16,15 @@ const (
 )
 
 func main() {
+
+	// Start Electron ourselves, unless it started us. Either way, the rest is the same.
+
+	err := electron.Launch(electron.LaunchOptions{Args: os.Args[1:]})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	report_window := electron.NewTextWindow("Reports", "pages/log.html", 400, 300, false, true)
 	main_window := electron.NewGridWindow("Timer", "pages/grid.html", WIDTH, HEIGHT, BOX_WIDTH, BOX_HEIGHT, 0, 0, FONT_PERCENT, true, false, false)
 
@@ -28,11 +38,7 @@ func main() {
 	for {
 		i++
 
-		s := fmt.Sprintf("%d", i)
-
-		for x := 0; x < len(s); x++ {
-			main_window.Set(x + 1, 1, string(s[x]), "g", "0")		// x, y, char, colour, bg-colour
-		}
+		main_window.Printf(1, 1, "g", "0", "%d", i)		// x, y, colour, bg-colour, format, args
 
 		main_window.Flip(nil)		// Optionally, send a (chan bool) as an argument and get a message when drawing is completed (or aborted).
 
@@ -0,0 +1,113 @@
+package electronbridge
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Text attributes of a cell, as a bitfield. Set() and SetRGB() clear them; use SetAttrs()
+// afterwards. Only cells with attributes are sent to the frontend.
+
+const (
+	ATTR_BOLD = 1 << iota
+	ATTR_ITALIC
+	ATTR_UNDERLINE
+	ATTR_STRIKETHROUGH
+	ATTR_REVERSE
+	ATTR_BLINK
+	ATTR_DIM
+)
+
+type attr_slice []uint8
+
+func (s attr_slice) MarshalJSON() ([]byte, error) {
+
+	// As with rgb_slice: {"index": attrs} for the cells which have any.
+
+	var buf bytes.Buffer
+
+	buf.WriteString("{")
+
+	first := true
+
+	for n, a := range s {
+		if a == 0 {
+			continue
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		fmt.Fprintf(&buf, `"%d":%d`, n, a)
+	}
+
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+func (b *CellBuffer) SetAttrs(x, y int, attrs uint8) {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	index := y * b.Width + x
+	if index < 0 || index >= len(b.Chars) || x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+
+	b.Attrs[index] = attrs
+}
+
+// ----------------------------------------------------------
+
+var attr_sgr = []struct {
+	attr			uint8
+	code			int
+}{
+	{ATTR_BOLD, 1},
+	{ATTR_DIM, 2},
+	{ATTR_ITALIC, 3},
+	{ATTR_UNDERLINE, 4},
+	{ATTR_BLINK, 5},
+	{ATTR_REVERSE, 7},
+	{ATTR_STRIKETHROUGH, 9},
+}
+
+func sgr_attrs(attrs uint8) string {
+
+	// Starts with a reset, since there's no general way to turn single attributes off.
+
+	codes := []string{"0"}
+
+	for _, item := range attr_sgr {
+		if attrs & item.attr != 0 {
+			codes = append(codes, fmt.Sprintf("%d", item.code))
+		}
+	}
+
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+func apply_colour_attrs(fg, bg color.RGBA, attrs uint8) (color.RGBA, color.RGBA) {
+
+	// For renderers that draw colours themselves: reverse swaps them, dim moves the
+	// foreground halfway to the background.
+
+	if attrs & ATTR_REVERSE != 0 {
+		fg, bg = bg, fg
+	}
+
+	if attrs & ATTR_DIM != 0 {
+		fg = color.RGBA{
+			uint8((int(fg.R) + int(bg.R)) / 2),
+			uint8((int(fg.G) + int(bg.G)) / 2),
+			uint8((int(fg.B) + int(bg.B)) / 2),
+			255,
+		}
+	}
+
+	return fg, bg
+}
@@ -0,0 +1,502 @@
+package electronbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Browser mode: the Go process serves the pages itself on a local HTTP server, and any ordinary
+// browser is the frontend. This file does the job main.js and windows.js do under Electron: it
+// keeps track of windows, queues messages until a window's page is ready, and translates what
+// the page sends (via electronbridge_js/browser_shim.js) into the messages listener() expects.
+//
+// Each window is a pane in the index page, electronbridge_js/browser.html, which talks to us
+// over its own "control" socket. There are no menus in this mode.
+//
+// Files are served from the working directory, but only those the pages need: see browser_allowed().
+// We only listen on loopback addresses, and requests must name one of those as their Host (and,
+// for sockets, Origin), so other machines and other web pages can't talk to us.
+
+const BROWSER_INDEX = "electronbridge_js/browser.html"
+const BROWSER_SHIM = "/electronbridge_js/browser_shim.js"
+
+type browser_window struct {
+	uid				int
+	config			json.RawMessage
+	conn			*ws_conn
+	ready			bool
+	queue			[][]byte
+	last_update		[]byte			// Resent if the page reconnects (e.g. the user reloads it).
+}
+
+type browser_state struct {
+	mutex			sync.Mutex
+	active			bool
+	windows			map[int]*browser_window
+	order			[]int			// Window creation order, for new control connections.
+	controls		map[*ws_conn]bool
+	port			string
+}
+
+var browser = browser_state{
+	windows: make(map[int]*browser_window),
+	controls: make(map[*ws_conn]bool),
+}
+
+func browser_active() bool {
+	browser.mutex.Lock()
+	defer browser.mutex.Unlock()
+	return browser.active
+}
+
+func StartBrowser(addr string) (string, error) {
+
+	// Call before creating any windows. addr is as for net.Listen, e.g. "127.0.0.1:8080",
+	// or "127.0.0.1:0" to pick a free port, but must be a loopback address. Returns the URL to open.
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("StartBrowser(): %v", err)
+	}
+
+	if !is_loopback(host) {
+		return "", fmt.Errorf("StartBrowser(): %q is not a loopback address", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("StartBrowser(): %v", err)
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", browser_serve_file)
+	mux.HandleFunc("/ws", browser_serve_socket)
+
+	browser.mutex.Lock()
+	browser.active = true
+	browser.port = port
+	browser.mutex.Unlock()
+
+	go http.Serve(listener, mux)
+
+	return fmt.Sprintf("http://%s/", listener.Addr().String()), nil
+}
+
+func is_loopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func browser_host_ok(hostport string) bool {
+
+	// Whether a Host or Origin names us. Checking the name, not just the port, stops a page on
+	// some other site from reaching us by pointing its own hostname at 127.0.0.1.
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return false
+	}
+
+	browser.mutex.Lock()
+	our_port := browser.port
+	browser.mutex.Unlock()
+
+	return port == our_port && is_loopback(host)
+}
+
+func browser_allowed(name string) bool {
+
+	// name is a cleaned path relative to the working directory.
+
+	if strings.HasPrefix(name, "pages/") || strings.HasPrefix(name, "electronbridge_js/") || name == "colours.json" {
+		return true
+	}
+
+	tilesets_mutex.Lock()
+	defer tilesets_mutex.Unlock()
+
+	for _, ts := range tilesets {
+		if filepath.ToSlash(filepath.Clean(ts.Filename)) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ----------------------------------------------------------
+
+func browser_serve_file(w http.ResponseWriter, r *http.Request) {
+
+	if !browser_host_ok(r.Host) {
+		http.Error(w, "bad host", http.StatusForbidden)
+		return
+	}
+
+	name := strings.TrimPrefix(filepath.Clean("/" + r.URL.Path), "/")
+
+	if name == "" {
+		name = BROWSER_INDEX
+	}
+
+	if !browser_allowed(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Window pages are written for Electron; the shim gives them a require() that works in a browser.
+
+	if strings.HasSuffix(name, ".html") && name != BROWSER_INDEX {
+		shim := []byte(fmt.Sprintf("<head>\n<script src=\"%s\"></script>", BROWSER_SHIM))
+		b = bytes.Replace(b, []byte("<head>"), shim, 1)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+}
+
+func browser_serve_socket(w http.ResponseWriter, r *http.Request) {
+
+	if !browser_host_ok(r.Host) {
+		http.Error(w, "bad host", http.StatusForbidden)
+		return
+	}
+
+	conn, err := ws_upgrade(w, r, func(origin string) bool {
+		return origin == r.Host
+	})
+	if err != nil {
+		return
+	}
+
+	if r.URL.Query().Get("control") != "" {
+		browser_control_loop(conn)
+		return
+	}
+
+	uid, err := strconv.Atoi(r.URL.Query().Get("uid"))
+	if err != nil {
+		conn.close()
+		return
+	}
+
+	browser_window_loop(conn, uid)
+}
+
+// ----------------------------------------------------------
+
+func browser_control_loop(conn *ws_conn) {
+
+	// The control connection belongs to the index page. We tell it about windows; it tells us nothing.
+
+	browser.mutex.Lock()
+	browser.controls[conn] = true
+	for _, uid := range browser.order {
+		browser_write(conn, browser_command("new", browser.windows[uid].config), false)
+	}
+	browser.mutex.Unlock()
+
+	for {
+		_, err := conn.read_message()
+		if err != nil {
+			break
+		}
+	}
+
+	browser.mutex.Lock()
+	delete(browser.controls, conn)
+	browser.mutex.Unlock()
+
+	conn.close()
+}
+
+func browser_window_loop(conn *ws_conn, uid int) {
+
+	browser.mutex.Lock()
+	bw := browser.windows[uid]
+	if bw == nil {
+		browser.mutex.Unlock()
+		conn.close()
+		return
+	}
+	if bw.conn != nil {
+		bw.conn.close()
+	}
+	bw.conn = conn
+	bw.ready = false
+	if bw.last_update != nil && len(bw.queue) == 0 {
+		bw.queue = append(bw.queue, bw.last_update)
+	}
+	browser.mutex.Unlock()
+
+	type page_msg struct {
+		Channel		string						`json:"channel"`
+		Msg			json.RawMessage				`json:"msg"`
+	}
+
+	for {
+		b, err := conn.read_message()
+		if err != nil {
+			break
+		}
+
+		var msg page_msg
+
+		err = json.Unmarshal(b, &msg)
+		if err != nil {
+			continue
+		}
+
+		browser_from_page(bw, msg.Channel, msg.Msg)
+	}
+
+	browser.mutex.Lock()
+	if bw.conn == conn {
+		bw.conn = nil
+		bw.ready = false
+	}
+	browser.mutex.Unlock()
+
+	conn.close()
+}
+
+func browser_from_page(bw *browser_window, channel string, raw json.RawMessage) {
+
+	// The equivalent of the ipcMain handlers in main.js.
+
+	var page_content struct {
+		Key			string						`json:"key"`
+		X			int							`json:"x"`
+		Y			int							`json:"y"`
+		Button		int							`json:"button"`
+		Msg			string						`json:"msg"`
+		XPixels		float64						`json:"xpixels"`
+		YPixels		float64						`json:"ypixels"`
+	}
+
+	json.Unmarshal(raw, &page_content)		// Fails harmlessly for "ack", whose content is a bare string.
+
+	switch channel {
+
+	case "ready":
+
+		browser.mutex.Lock()
+		if bw.conn == nil {
+			browser.mutex.Unlock()
+			return
+		}
+		bw.ready = true
+		browser_write(bw.conn, browser_page_message("init", bw.config), false)
+		for _, m := range bw.queue {
+			browser_write(bw.conn, m, false)
+		}
+		bw.queue = nil
+		browser.mutex.Unlock()
+
+	case "ack":
+
+		var ack string
+		json.Unmarshal(raw, &ack)
+		browser_to_listener("ack", map[string]interface{}{"ackmessage": ack})
+
+	case "keydown", "keyup":
+
+		browser_to_listener("key", map[string]interface{}{"down": channel == "keydown", "uid": bw.uid, "key": page_content.Key})
+
+	case "mousedown", "mouseup":
+
+		browser_to_listener("mouse", map[string]interface{}{"down": channel == "mousedown", "uid": bw.uid,
+			"x": page_content.X, "y": page_content.Y, "button": page_content.Button})
+
+	case "mouseover":
+
+		browser_to_listener("mouseover", map[string]interface{}{"uid": bw.uid, "x": page_content.X, "y": page_content.Y})
+
+	case "request_resize":
+
+		browser_to_controls("resize", map[string]interface{}{"uid": bw.uid, "xpixels": page_content.XPixels, "ypixels": page_content.YPixels})
+
+	case "log", "error":
+
+		browser_to_controls("log", map[string]interface{}{"uid": bw.uid, "error": channel == "error", "msg": page_content.Msg})
+	}
+}
+
+func browser_to_listener(msg_type string, content interface{}) {
+
+	b, err := json.Marshal(map[string]interface{}{"type": msg_type, "content": content})
+	if err != nil {
+		return
+	}
+
+	if !replaying() {
+		incoming_line_chan <- b
+	}
+}
+
+func browser_to_controls(command string, content interface{}) {
+
+	b, err := json.Marshal(content)
+	if err != nil {
+		return
+	}
+
+	browser.mutex.Lock()
+	defer browser.mutex.Unlock()
+
+	for conn := range browser.controls {
+		browser_write(conn, browser_command(command, b), false)
+	}
+}
+
+// ----------------------------------------------------------
+
+func browser_send(b []byte) {
+
+	// Called by send_command_and_content() in place of writing to stdout.
+	// b is the marshalled outgoing_msg, without newline.
+
+	var msg struct {
+		Command		string						`json:"command"`
+		Content		json.RawMessage				`json:"content"`
+	}
+
+	var target struct {
+		Uid			int							`json:"uid"`
+		Chars		json.RawMessage				`json:"chars"`		// Present for grids only.
+	}
+
+	err := json.Unmarshal(b, &msg)
+	if err != nil {
+		return
+	}
+
+	json.Unmarshal(msg.Content, &target)
+
+	browser.mutex.Lock()
+	defer browser.mutex.Unlock()
+
+	switch msg.Command {
+
+	case "new":
+
+		browser.windows[target.Uid] = &browser_window{uid: target.Uid, config: msg.Content}
+		browser.order = append(browser.order, target.Uid)
+
+		for conn := range browser.controls {
+			browser_write(conn, b, false)
+		}
+
+	case "update", "effect":
+
+		bw := browser.windows[target.Uid]
+		if bw == nil {
+			return
+		}
+
+		m := browser_page_message(msg.Command, msg.Content)
+		is_frame := msg.Command == "update" && target.Chars != nil
+		previous := bw.last_update
+
+		if is_frame {
+			bw.last_update = m
+		}
+
+		if bw.conn != nil && bw.ready {
+			browser_write(bw.conn, m, is_frame)
+			return
+		}
+
+		if msg.Command == "update" {
+			browser_queue_update(bw, m, previous, is_frame)
+		}
+
+	case "palette", "tileset":		// tileset messages have no uid, so go to every window
+
+		m := browser_page_message(msg.Command, msg.Content)
+
+		for uid, bw := range browser.windows {
+			if target.Uid != 0 && uid != target.Uid {
+				continue
+			}
+			if bw.conn != nil && bw.ready {
+				browser_write(bw.conn, m, false)
+			} else {
+				bw.queue = append(bw.queue, m)
+			}
+		}
+
+	case "alert", "front", "silentlog":
+
+		for conn := range browser.controls {
+			browser_write(conn, b, false)
+		}
+	}
+}
+
+func browser_write(conn *ws_conn, m []byte, droppable bool) {
+
+	// Hands m to the connection's writer. If the page has fallen that far behind, a grid frame is
+	// simply dropped (like a BackendCanDrop frame under Electron) but anything else would leave the
+	// page wrong, so it's disconnected instead; reloading it gets the last frame.
+
+	if !conn.send(m) && !droppable {
+		conn.close()
+	}
+}
+
+func browser_queue_update(bw *browser_window, m, previous []byte, is_frame bool) {
+
+	// Nobody is watching; there's no point keeping more than one grid frame, but text windows
+	// need every update. previous is the last frame before m, which m replaces if it's queued.
+	// Frames we discard are never acked, but Flip() times out waiting anyway.
+
+	if is_frame && previous != nil {
+		for n, queued := range bw.queue {
+			if bytes.Equal(queued, previous) {
+				bw.queue[n] = m
+				return
+			}
+		}
+	}
+
+	bw.queue = append(bw.queue, m)
+}
+
+func browser_page_message(channel string, content []byte) []byte {
+
+	// What the shim turns into an ipcRenderer event on the given channel.
+
+	type page_message struct {
+		Channel		string						`json:"channel"`
+		Msg			json.RawMessage				`json:"msg"`
+	}
+
+	b, _ := json.Marshal(page_message{Channel: channel, Msg: content})
+	return b
+}
+
+func browser_command(command string, content []byte) []byte {
+	b, _ := json.Marshal(outgoing_msg{Command: command, Content: json.RawMessage(content)})
+	return b
+}
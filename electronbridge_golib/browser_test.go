@@ -0,0 +1,76 @@
+package electronbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBrowserQueueKeepsOneFrame(t *testing.T) {
+
+	// With no page connected, each grid frame replaces the one queued before it.
+
+	const uid = 1000001
+
+	browser.mutex.Lock()
+	browser.windows[uid] = &browser_window{uid: uid}
+	browser.mutex.Unlock()
+
+	defer func() {
+		browser.mutex.Lock()
+		delete(browser.windows, uid)
+		browser.mutex.Unlock()
+	}()
+
+	for n := 0; n < 50; n++ {
+		b, _ := json.Marshal(outgoing_msg{Command: "update", Content: map[string]interface{}{"uid": uid, "chars": fmt.Sprintf("frame %d", n)}})
+		browser_send(b)
+	}
+
+	browser.mutex.Lock()
+	queue := browser.windows[uid].queue
+	browser.mutex.Unlock()
+
+	if len(queue) != 1 {
+		t.Fatalf("queue has %d frames, want 1", len(queue))
+	}
+
+	var m struct {
+		Msg			struct {
+			Chars		string				`json:"chars"`
+		}									`json:"msg"`
+	}
+
+	json.Unmarshal(queue[0], &m)
+	if m.Msg.Chars != "frame 49" {
+		t.Errorf("queued frame is %q, want the last one", m.Msg.Chars)
+	}
+}
+
+func TestBrowserQueueKeepsTextUpdates(t *testing.T) {
+
+	const uid = 1000002
+
+	browser.mutex.Lock()
+	browser.windows[uid] = &browser_window{uid: uid}
+	browser.mutex.Unlock()
+
+	defer func() {
+		browser.mutex.Lock()
+		delete(browser.windows, uid)
+		browser.mutex.Unlock()
+	}()
+
+	for n := 0; n < 5; n++ {
+		b, _ := json.Marshal(outgoing_msg{Command: "update", Content: map[string]interface{}{"uid": uid, "msg": n}})
+		browser_send(b)
+	}
+
+	browser.mutex.Lock()
+	queue := browser.windows[uid].queue
+	browser.mutex.Unlock()
+
+	if len(queue) != 5 {
+		t.Errorf("queue has %d updates, want 5", len(queue))
+	}
+}
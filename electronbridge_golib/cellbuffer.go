@@ -0,0 +1,434 @@
+package electronbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The cells of a grid. A GridWindow is a CellBuffer which can be flipped to the screen, and all
+// the drawing methods work on both; a CellBuffer on its own is drawn into offscreen and then
+// blitted onto a window, either directly or as one of the window's layers.
+
+const (
+	CLEAR_CHAR = " "
+	CLEAR_COLOUR = "w"
+	CLEAR_BACKGROUND = "0"
+	RGB_KEY = "#"				// The colour key of cells set with SetRGB(). Not usable in colours.json.
+)
+
+type Spot struct {
+	Char			string
+	Colour			string
+	Background		string
+	ColourRGB		color.RGBA		// Only meaningful if Colour == RGB_KEY
+	BackgroundRGB	color.RGBA		// Only meaningful if Background == RGB_KEY
+	Attrs			uint8			// ATTR_BOLD, etc.
+	Tile			int				// NO_TILE unless set with SetTile()
+	Tint			color.RGBA		// Alpha 0 means none
+}
+
+type string_slice []string	// For convenience, things that should really be runes are stored as strings
+
+func (s string_slice) MarshalJSON() ([]byte, error) {	// Marshalling them means concatenation
+	str := strings.Join(s, "")
+	return json.Marshal(str)
+}
+
+type rgb_slice []color.RGBA	// Alpha 0 means not set; the cell uses its palette key instead.
+
+func (s rgb_slice) MarshalJSON() ([]byte, error) {
+
+	// Most cells don't have a truecolour, so only those that do are sent, as {"index": "#rrggbb"}.
+
+	var buf bytes.Buffer
+
+	buf.WriteString("{")
+
+	first := true
+
+	for n, c := range s {
+		if c.A == 0 {
+			continue
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		fmt.Fprintf(&buf, `"%d":"%s"`, n, css_hex(c))
+	}
+
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+type CellBuffer struct {
+	Width				int							`json:"width"`
+	Height				int							`json:"height"`
+	Chars				string_slice				`json:"chars"`
+	Colours				string_slice				`json:"colours"`
+	Backgrounds			string_slice				`json:"backgrounds"`
+	ColourRGBs			rgb_slice					`json:"colourrgbs"`		// For cells whose colour key is RGB_KEY
+	BackgroundRGBs		rgb_slice					`json:"backgroundrgbs"`	// As above
+	Attrs				attr_slice					`json:"attrs"`			// See attrs.go
+	Tiles				tile_slice					`json:"tiles"`			// See tiles.go
+	Tints				rgb_slice					`json:"tints"`			// As above
+
+	Mutex				sync.Mutex					`json:"-"`
+	Palette				map[string]string			`json:"-"`		// Set by GridWindow.SetPalette(); nil means the global palette
+	UnknownKeys			map[string]bool				`json:"-"`		// Colour keys already warned about
+	OwnerUid			int							`json:"-"`		// The GridWindow's Uid, if this is one; for log messages
+
+	// When this buffer is blitted, cells whose char is TransparentChar are skipped (unless they have
+	// a tile), and cells whose
+	// background is TransparentBackground keep the background underneath. "" means none.
+
+	TransparentChar			string					`json:"-"`
+	TransparentBackground	string					`json:"-"`
+}
+
+func NewCellBuffer(width, height int) *CellBuffer {
+	b := new(CellBuffer)
+	b.init(width, height)
+	return b
+}
+
+func (b *CellBuffer) init(width, height int) {
+
+	b.Width = width
+	b.Height = height
+
+	b.Chars = make([]string, width * height)
+	b.Colours = make([]string, width * height)
+	b.Backgrounds = make([]string, width * height)
+	b.ColourRGBs = make([]color.RGBA, width * height)
+	b.BackgroundRGBs = make([]color.RGBA, width * height)
+	b.Attrs = make([]uint8, width * height)
+	b.Tiles = make([]int, width * height)
+	b.Tints = make([]color.RGBA, width * height)
+
+	b.Clear()
+}
+
+func (b *CellBuffer) Set(x, y int, char, colour, background string) {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	if utf8.RuneCountInString(char) != 1 {
+		panic("CellBuffer.Set(): utf8.RuneCountInString(char) != 1")
+	}
+
+	if utf8.RuneCountInString(colour) != 1 {
+		panic("CellBuffer.Set(): utf8.RuneCountInString(colour) != 1")
+	}
+
+	if utf8.RuneCountInString(background) != 1 {
+		panic("CellBuffer.Set(): utf8.RuneCountInString(background) != 1")
+	}
+
+	b.set_wide(x, y, char, colour, background)
+}
+
+func (b *CellBuffer) set(x, y int, char, colour, background string) {
+
+	// Clipped to the buffer. The caller holds b.Mutex and has checked the arguments.
+
+	index := y * b.Width + x
+	if index < 0 || index >= len(b.Chars) || x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+
+	b.check_key(colour)
+	b.check_key(background)
+
+	b.break_wide(x, y)
+
+	b.Chars[index] = char
+	b.Colours[index] = colour
+	b.Backgrounds[index] = background
+	b.ColourRGBs[index] = color.RGBA{}
+	b.BackgroundRGBs[index] = color.RGBA{}
+	b.Attrs[index] = 0
+	b.Tiles[index] = NO_TILE
+	b.Tints[index] = color.RGBA{}
+}
+
+func (b *CellBuffer) SetRGB(x, y int, char string, colour, background color.Color) {
+
+	// As Set(), but with any colours rather than palette keys. Alpha is ignored.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	if utf8.RuneCountInString(char) != 1 {
+		panic("CellBuffer.SetRGB(): utf8.RuneCountInString(char) != 1")
+	}
+
+	b.set_wide_rgb(x, y, char, opaque_rgba(colour), opaque_rgba(background))
+}
+
+func (b *CellBuffer) check_key(key string) {
+
+	// Unknown colour keys are drawn white. Say so, once per key, since it's probably a mistake.
+	// The caller holds b.Mutex.
+
+	if key == RGB_KEY || b.UnknownKeys[key] {
+		return
+	}
+
+	if _, ok := b.palette()[key]; ok {
+		return
+	}
+
+	if b.UnknownKeys == nil {
+		b.UnknownKeys = make(map[string]bool)
+	}
+	b.UnknownKeys[key] = true
+
+	if b.OwnerUid != 0 {
+		Logf("Grid UID %d: colour key '%s' is not in the palette", b.OwnerUid, key)
+	} else {
+		Logf("CellBuffer: colour key '%s' is not in the palette", key)
+	}
+}
+
+func (b *CellBuffer) palette() map[string]string {
+
+	// The caller holds b.Mutex.
+
+	if b.Palette != nil {
+		return b.Palette
+	}
+	return active_palette()
+}
+
+func opaque_rgba(c color.Color) color.RGBA {
+	ret := color.RGBAModel.Convert(c).(color.RGBA)
+	ret.A = 255
+	return ret
+}
+
+func (b *CellBuffer) Get(x, y int) Spot {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	index := y * b.Width + x
+	if index < 0 || index >= len(b.Chars) || x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return Spot{Char: CLEAR_CHAR, Colour: CLEAR_COLOUR, Background: CLEAR_BACKGROUND}
+	}
+
+	return Spot{
+		Char: b.Chars[index],
+		Colour: b.Colours[index],
+		Background: b.Backgrounds[index],
+		ColourRGB: b.ColourRGBs[index],
+		BackgroundRGB: b.BackgroundRGBs[index],
+		Attrs: b.Attrs[index],
+		Tile: b.Tiles[index],
+		Tint: b.Tints[index],
+	}
+}
+
+func (b *CellBuffer) Clear() {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	for n := 0; n < len(b.Chars); n++ {
+		b.Chars[n] = CLEAR_CHAR
+		b.Colours[n] = CLEAR_COLOUR
+		b.Backgrounds[n] = CLEAR_BACKGROUND
+		b.ColourRGBs[n] = color.RGBA{}
+		b.BackgroundRGBs[n] = color.RGBA{}
+		b.Attrs[n] = 0
+		b.Tiles[n] = NO_TILE
+		b.Tints[n] = color.RGBA{}
+	}
+}
+
+
+// ----------------------------------------------------------
+
+func (b *CellBuffer) Blit(src *CellBuffer, x, y int) {
+
+	// Draws src onto b with its top left corner at x, y, honouring src's transparency.
+
+	src.Mutex.Lock()
+	s := src.snapshot()
+	transparent_char, transparent_background := src.TransparentChar, src.TransparentBackground
+	src.Mutex.Unlock()
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	b.blit(s, transparent_char, transparent_background, x, y)
+}
+
+func (b *CellBuffer) blit(s *grid_snapshot, transparent_char, transparent_background string, x, y int) {
+
+	// The caller holds b.Mutex.
+
+	written := func(i, j int) bool {
+		src_index := j * s.Width + i
+		return transparent_char == "" || s.Chars[src_index] != transparent_char || s.Tiles[src_index] != NO_TILE
+	}
+
+	// As in set(), a wide character loses its other half when one half is overwritten. This is done
+	// first, so as not to break pairs which were just copied.
+
+	for j := 0; j < s.Height; j++ {
+		for i := 0; i < s.Width; i++ {
+			dx, dy := x + i, y + j
+			if dx >= 0 && dx < b.Width && dy >= 0 && dy < b.Height && written(i, j) {
+				b.break_wide(dx, dy)
+			}
+		}
+	}
+
+	defer b.repair_wide(Rect{x, y, s.Width, s.Height}.clip(b.Width, b.Height))
+
+	for j := 0; j < s.Height; j++ {
+		for i := 0; i < s.Width; i++ {
+
+			dx, dy := x + i, y + j
+			if dx < 0 || dx >= b.Width || dy < 0 || dy >= b.Height {
+				continue
+			}
+
+			src_index := j * s.Width + i
+			index := dy * b.Width + dx
+
+			if !written(i, j) {
+				continue
+			}
+
+			b.Chars[index] = s.Chars[src_index]
+			b.Colours[index] = s.Colours[src_index]
+			b.ColourRGBs[index] = s.ColourRGBs[src_index]
+			b.Attrs[index] = s.Attrs[src_index]
+			b.Tiles[index] = s.Tiles[src_index]
+			b.Tints[index] = s.Tints[src_index]
+
+			if transparent_background != "" && s.Backgrounds[src_index] == transparent_background {
+				continue
+			}
+
+			b.Backgrounds[index] = s.Backgrounds[src_index]
+			b.BackgroundRGBs[index] = s.BackgroundRGBs[src_index]
+		}
+	}
+}
+
+// ----------------------------------------------------------
+// Layers are buffers which a GridWindow composites over its own cells whenever it flips, in order
+// of z (lowest first), so each can be redrawn on its own: say, map, then entities, then UI. The
+// window's own cells are not changed by this.
+
+type Layer struct {
+	Buffer			*CellBuffer
+	window			*GridWindow
+	x				int
+	y				int
+	z				int
+	hidden			bool
+}
+
+func (w *GridWindow) AddLayer(buffer *CellBuffer, x, y, z int) *Layer {
+
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+
+	l := &Layer{Buffer: buffer, window: w, x: x, y: y, z: z}
+	w.Layers = append(w.Layers, l)
+
+	return l
+}
+
+func (w *GridWindow) RemoveLayer(l *Layer) {
+
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+
+	for n, other := range w.Layers {
+		if other == l {
+			w.Layers = append(w.Layers[:n], w.Layers[n + 1:]...)
+			return
+		}
+	}
+}
+
+func (l *Layer) Move(x, y int) {
+	l.window.Mutex.Lock()
+	defer l.window.Mutex.Unlock()
+	l.x, l.y = x, y
+}
+
+func (l *Layer) SetZ(z int) {
+	l.window.Mutex.Lock()
+	defer l.window.Mutex.Unlock()
+	l.z = z
+}
+
+func (l *Layer) SetHidden(hidden bool) {
+	l.window.Mutex.Lock()
+	defer l.window.Mutex.Unlock()
+	l.hidden = hidden
+}
+
+func (w *GridWindow) composite() *GridWindow {
+
+	// Returns the frame to send: w itself if there are no layers to show, else a new GridWindow
+	// holding the composited cells. The caller holds w.Mutex.
+
+	if len(w.Layers) == 0 {
+		return w
+	}
+
+	var layers []*Layer
+
+	for _, l := range w.Layers {
+		if !l.hidden {
+			layers = append(layers, l)
+		}
+	}
+
+	if len(layers) == 0 {
+		return w
+	}
+
+	sort.SliceStable(layers, func(a, b int) bool {
+		return layers[a].z < layers[b].z
+	})
+
+	frame := &GridWindow{
+		Uid: w.Uid,
+		CameraX: w.CameraX,
+		CameraY: w.CameraY,
+		Title: w.Title,
+		AckRequired: w.AckRequired,
+	}
+
+	frame.init(w.Width, w.Height)
+	frame.Palette = w.Palette
+	frame.blit(w.snapshot(), "", "", 0, 0)
+
+	for _, l := range layers {
+
+		l.Buffer.Mutex.Lock()
+		s := l.Buffer.snapshot()
+		transparent_char, transparent_background := l.Buffer.TransparentChar, l.Buffer.TransparentBackground
+		l.Buffer.Mutex.Unlock()
+
+		frame.blit(s, transparent_char, transparent_background, l.x, l.y)
+	}
+
+	return frame
+}
@@ -0,0 +1,154 @@
+package electronbridge
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Command ids and accelerators are claimed here by every source of commands (RegisterCommand,
+// RegisterCommandFunc and the menu model) so that clashes can be reported. Commands can also
+// have handlers, which DispatchPending() calls instead of the app polling GetCommand().
+
+var command_mutex sync.Mutex
+var command_ids = make(map[string]bool)
+var command_accels = make(map[string]string)		// normalised accelerator --> id
+var command_handlers = make(map[string]func())
+
+func RegisterCommandFunc(id, label, accel string, f func()) error {
+
+	// Like RegisterCommand(), but the id is separate from the label, and when the command
+	// is chosen, f is called by the next DispatchPending().
+
+	err := claim_command(id, accel)
+	if err != nil {
+		return fmt.Errorf("RegisterCommandFunc(): %v", err)
+	}
+
+	OnCommand(id, f)
+	send_register(id, label, accel)
+
+	return nil
+}
+
+func OnCommand(id string, f func()) {
+
+	// Sets (or with nil, removes) the handler for any command id, e.g. one from the menu model.
+	// Commands with handlers no longer show up in GetCommand().
+
+	command_mutex.Lock()
+	defer command_mutex.Unlock()
+
+	if f == nil {
+		delete(command_handlers, id)
+	} else {
+		command_handlers[id] = f
+	}
+}
+
+func DispatchPending() int {
+
+	// Calls the handlers of every command chosen since the last call, in order, on the
+	// caller's goroutine. Returns how many were called.
+
+	response_chan := make(chan []string)
+	dispatch_query_chan <- response_chan
+
+	ids := <- response_chan
+
+	for _, id := range ids {
+		f := command_handler(id)
+		if f != nil {
+			f()
+		}
+	}
+
+	return len(ids)
+}
+
+func command_handler(id string) func() {
+	command_mutex.Lock()
+	defer command_mutex.Unlock()
+	return command_handlers[id]
+}
+
+// ----------------------------------------------------------
+
+func claim_command(id, accel string) error {
+
+	command_mutex.Lock()
+	defer command_mutex.Unlock()
+
+	if id == "" {
+		return fmt.Errorf("empty command id")
+	}
+
+	if command_ids[id] {
+		return fmt.Errorf("duplicate command id '%s'", id)
+	}
+
+	norm := normalise_accelerator(accel)
+
+	if norm != "" {
+		if other, ok := command_accels[norm]; ok {
+			return fmt.Errorf("accelerator '%s' of '%s' is already used by '%s'", accel, id, other)
+		}
+		command_accels[norm] = id
+	}
+
+	command_ids[id] = true
+
+	return nil
+}
+
+func normalise_accelerator(accel string) string {
+
+	// So that e.g. "Ctrl+Shift+S", "shift+control+s" and (except on a Mac) "CmdOrCtrl+Shift+S"
+	// all come out the same.
+
+	if strings.TrimSpace(accel) == "" {
+		return ""
+	}
+
+	aliases := map[string]string{
+		"control": "ctrl",
+		"command": "cmd",
+		"super": "cmd",
+		"option": "alt",
+		"altgr": "alt",
+		"return": "enter",
+		"esc": "escape",
+	}
+
+	cmd_or_ctrl := "ctrl"
+	if runtime.GOOS == "darwin" {
+		cmd_or_ctrl = "cmd"
+	}
+
+	parts := strings.Split(strings.ToLower(accel), "+")
+
+	for n := range parts {
+		parts[n] = strings.TrimSpace(parts[n])		// "Ctrl + S" is "Ctrl+S"
+	}
+
+	if len(parts) >= 2 && parts[len(parts) - 1] == "" && parts[len(parts) - 2] == "" {	// The key itself is "+"
+		parts = append(parts[:len(parts) - 2], "plus")
+	}
+
+	for n, part := range parts {
+		if alias, ok := aliases[part]; ok {
+			part = alias
+		}
+		if part == "cmdorctrl" || part == "commandorcontrol" {
+			part = cmd_or_ctrl
+		}
+		parts[n] = part
+	}
+
+	modifiers := parts[:len(parts) - 1]
+	sort.Strings(modifiers)
+
+	return strings.Join(append(modifiers, parts[len(parts) - 1]), "+")
+}
@@ -0,0 +1,33 @@
+package electronbridge
+
+import (
+	"testing"
+)
+
+func TestNormaliseAccelerator(t *testing.T) {
+
+	same := [][]string{
+		{"Ctrl+S", "ctrl+s", "Control+S", "Ctrl + S", " ctrl +s "},
+		{"Ctrl+Shift+S", "shift+control+s", "Shift + Ctrl + S"},
+		{"Ctrl++", "Ctrl + +", "ctrl+plus"},
+		{"+", "Plus"},
+		{"Alt+Esc", "Option + Escape"},
+	}
+
+	for _, group := range same {
+		want := normalise_accelerator(group[0])
+		for _, accel := range group[1:] {
+			if got := normalise_accelerator(accel); got != want {
+				t.Errorf("normalise_accelerator(%q) = %q, want %q (as for %q)", accel, got, want, group[0])
+			}
+		}
+	}
+
+	if normalise_accelerator("Ctrl+S") == normalise_accelerator("Ctrl+Shift+S") {
+		t.Errorf("Ctrl+S and Ctrl+Shift+S normalise the same")
+	}
+
+	if normalise_accelerator("  ") != "" {
+		t.Errorf("blank accelerator isn't \"\"")
+	}
+}
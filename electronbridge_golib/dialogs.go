@@ -0,0 +1,134 @@
+package electronbridge
+
+import (
+	"context"
+	"errors"
+)
+
+// Modal dialogs that return the user's answer. Each blocks until the user answers or ctx is done;
+// in the latter case the dialog stays on screen but its answer goes nowhere.
+
+var ErrCancelled = errors.New("dialog cancelled")
+
+type message_box_args struct {
+	Kind			string						`json:"kind"`			// "confirm", "prompt" or "choose"
+	Message			string						`json:"message"`
+	Buttons			[]string					`json:"buttons"`
+	Default			string						`json:"default"`
+}
+
+type message_box_result struct {
+	Response		int							`json:"response"`		// Index of the button pressed
+	Text			string						`json:"text"`
+	Cancelled		bool						`json:"cancelled"`
+}
+
+func Confirm(ctx context.Context, msg string) (bool, error) {
+
+	var result message_box_result
+
+	err := request(ctx, "dialog", message_box_args{Kind: "confirm", Message: msg, Buttons: []string{"OK", "Cancel"}}, &result)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Response == 0, nil
+}
+
+func Prompt(ctx context.Context, msg string, default_text string) (string, error) {
+
+	// Returns ErrCancelled if the user cancels, so an empty answer can be told apart.
+
+	var result message_box_result
+
+	err := request(ctx, "dialog", message_box_args{Kind: "prompt", Message: msg, Default: default_text}, &result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.Cancelled {
+		return "", ErrCancelled
+	}
+
+	return result.Text, nil
+}
+
+func Choose(ctx context.Context, msg string, buttons []string) (int, error) {
+
+	// Returns the index of the button pressed. Escape counts as whichever button Electron
+	// thinks means cancel (one labelled "Cancel" or "No", else the first).
+
+	if len(buttons) == 0 {
+		return -1, errors.New("Choose(): no buttons")
+	}
+
+	var result message_box_result
+
+	err := request(ctx, "dialog", message_box_args{Kind: "choose", Message: msg, Buttons: buttons}, &result)
+	if err != nil {
+		return -1, err
+	}
+
+	return result.Response, nil
+}
+
+// ----------------------------------------------------------
+
+type FileFilter struct {
+	Name			string						`json:"name"`			// e.g. "Maps"
+	Extensions		[]string					`json:"extensions"`		// e.g. ["map", "txt"], no dots; "*" for all
+}
+
+type FileDialogOptions struct {
+	Title			string						`json:"title"`
+	DefaultPath		string						`json:"defaultpath"`
+	Filters			[]FileFilter				`json:"filters"`
+	Multiple		bool						`json:"multiple"`		// Open only
+	Directory		bool						`json:"directory"`		// Open only: choose directories instead of files
+}
+
+type file_dialog_args struct {
+	FileDialogOptions
+	Kind			string						`json:"kind"`			// "open" or "save"
+}
+
+type file_dialog_result struct {
+	Paths			[]string					`json:"paths"`
+	Cancelled		bool						`json:"cancelled"`
+}
+
+func OpenFileDialog(ctx context.Context, opts FileDialogOptions) ([]string, error) {
+
+	// Returns ErrCancelled if the user cancels.
+
+	var result file_dialog_result
+
+	err := request(ctx, "filedialog", file_dialog_args{FileDialogOptions: opts, Kind: "open"}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Cancelled || len(result.Paths) == 0 {
+		return nil, ErrCancelled
+	}
+
+	return result.Paths, nil
+}
+
+func SaveFileDialog(ctx context.Context, opts FileDialogOptions) (string, error) {
+
+	// Returns ErrCancelled if the user cancels.
+
+	var result file_dialog_result
+
+	err := request(ctx, "filedialog", file_dialog_args{FileDialogOptions: opts, Kind: "save"}, &result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.Cancelled || len(result.Paths) == 0 || result.Paths[0] == "" {
+		return "", ErrCancelled
+	}
+
+	return result.Paths[0], nil
+}
@@ -0,0 +1,228 @@
+package electronbridge
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Drawing on a grid more than one cell at a time. Everything is clipped to the buffer.
+
+type BoxStyle struct {
+	Horizontal		string
+	Vertical		string
+	TopLeft			string
+	TopRight		string
+	BottomLeft		string
+	BottomRight		string
+}
+
+var BOX_SINGLE = BoxStyle{"─", "│", "┌", "┐", "└", "┘"}
+var BOX_DOUBLE = BoxStyle{"═", "║", "╔", "╗", "╚", "╝"}
+var BOX_ASCII = BoxStyle{"-", "|", "+", "+", "+", "+"}
+
+func check_colours(caller, colour, background string) {
+	if utf8.RuneCountInString(colour) != 1 {
+		panic(fmt.Sprintf("CellBuffer.%s(): utf8.RuneCountInString(colour) != 1", caller))
+	}
+	if utf8.RuneCountInString(background) != 1 {
+		panic(fmt.Sprintf("CellBuffer.%s(): utf8.RuneCountInString(background) != 1", caller))
+	}
+}
+
+func check_char(caller, char string) {
+	if utf8.RuneCountInString(char) != 1 {
+		panic(fmt.Sprintf("CellBuffer.%s(): utf8.RuneCountInString(char) != 1", caller))
+	}
+}
+
+func check_narrow(caller, char string) {
+	if CharWidth(char) != 1 {
+		panic(fmt.Sprintf("CellBuffer.%s(): CharWidth(char) != 1", caller))
+	}
+}
+
+// ----------------------------------------------------------
+
+func (b *CellBuffer) Print(x, y int, s, colour, background string) {
+
+	// One rune per cell (two if wide, see wide.go), starting at x, y. A newline goes to the next row, back at x.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	check_colours("Print", colour, background)
+
+	i := x
+
+	for _, c := range s {
+		if c == '\n' {
+			i = x
+			y++
+			continue
+		}
+		i += b.set_wide(i, y, string(c), colour, background)
+	}
+}
+
+func (b *CellBuffer) Printf(x, y int, colour, background string, format_string string, args ...interface{}) {
+	b.Print(x, y, fmt.Sprintf(format_string, args...), colour, background)
+}
+
+func (b *CellBuffer) FillRect(x, y, width, height int, char, colour, background string) {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	check_char("FillRect", char)
+	check_colours("FillRect", colour, background)
+
+	b.fill_rect(x, y, width, height, char, colour, background)
+}
+
+func (b *CellBuffer) ClearRect(x, y, width, height int) {
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	b.fill_rect(x, y, width, height, CLEAR_CHAR, CLEAR_COLOUR, CLEAR_BACKGROUND)
+}
+
+func (b *CellBuffer) fill_rect(x, y, width, height int, char, colour, background string) {
+
+	// The caller holds b.Mutex.
+
+	r := Rect{x, y, width, height}.clip(b.Width, b.Height)
+
+	for j := r.Y; j < r.Y + r.Height; j++ {
+		for i := r.X; i < r.X + r.Width; {
+			if i == r.X + r.Width - 1 && CharWidth(char) == 2 {
+				b.set(i, j, CLEAR_CHAR, colour, background)		// A wide character would stick out of the rect.
+				break
+			}
+			i += b.set_wide(i, j, char, colour, background)
+		}
+	}
+}
+
+func (b *CellBuffer) DrawBox(x, y, width, height int, style BoxStyle, colour, background string) {
+
+	// Just the border; the inside is left alone.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	for _, char := range []string{style.Horizontal, style.Vertical, style.TopLeft, style.TopRight, style.BottomLeft, style.BottomRight} {
+		check_char("DrawBox", char)
+		check_narrow("DrawBox", char)
+	}
+	check_colours("DrawBox", colour, background)
+
+	if width < 1 || height < 1 {
+		return
+	}
+
+	right, bottom := x + width - 1, y + height - 1
+
+	for i := x + 1; i < right; i++ {
+		b.set_wide(i, y, style.Horizontal, colour, background)
+		b.set_wide(i, bottom, style.Horizontal, colour, background)
+	}
+
+	for j := y + 1; j < bottom; j++ {
+		b.set_wide(x, j, style.Vertical, colour, background)
+		b.set_wide(right, j, style.Vertical, colour, background)
+	}
+
+	b.set_wide(x, y, style.TopLeft, colour, background)
+	b.set_wide(right, y, style.TopRight, colour, background)
+	b.set_wide(x, bottom, style.BottomLeft, colour, background)
+	b.set_wide(right, bottom, style.BottomRight, colour, background)
+}
+
+func (b *CellBuffer) DrawLine(x1, y1, x2, y2 int, char, colour, background string) {
+
+	// Bresenham's line, including both ends. Each point is worked out from its step along the
+	// major axis (giving the same points as the usual loop), so that only the steps which land
+	// inside the buffer need be visited, however long the line.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	check_char("DrawLine", char)
+	check_colours("DrawLine", colour, background)
+
+	dx, dy := abs(x2 - x1), abs(y2 - y1)
+	sx, sy := 1, 1
+	if x1 > x2 {
+		sx = -1
+	}
+	if y1 > y2 {
+		sy = -1
+	}
+
+	steps := dx
+	if dy > dx {
+		steps = dy
+	}
+
+	point := func(k int) (int, int) {
+		if steps == 0 {
+			return x1, y1
+		}
+		if dx >= dy {
+			return x1 + sx * k, y1 + sy * ((2 * k * dy + dx) / (2 * dx))
+		}
+		return x1 + sx * ((2 * k * dx + dy) / (2 * dy)), y1 + sy * k
+	}
+
+	// Both coordinates change monotonically with k, so each is inside the buffer for one run of steps.
+
+	first, last := clip_steps(steps, func(k int) int { x, _ := point(k); return x }, sx, b.Width)
+	first_y, last_y := clip_steps(steps, func(k int) int { _, y := point(k); return y }, sy, b.Height)
+
+	if first_y > first {
+		first = first_y
+	}
+	if last_y < last {
+		last = last_y
+	}
+
+	// A wide character covers two cells, so along a horizontal run, a point whose cells overlap the
+	// previous point's is skipped.
+
+	width := CharWidth(char)
+	prev_x, prev_y := 0, -1
+
+	for k := first; k <= last; k++ {
+		x, y := point(k)
+		if y == prev_y && x < prev_x + width && prev_x < x + width {
+			continue
+		}
+		b.set_wide(x, y, char, colour, background)
+		prev_x, prev_y = x, y
+	}
+}
+
+func clip_steps(steps int, f func(int) int, direction, size int) (int, int) {
+
+	// f(k) moves in the given direction (1 or -1) as k goes from 0 to steps. Returns the
+	// first and last k for which 0 <= f(k) < size; last < first if there are none.
+
+	lo, hi := 0, size					// For direction * f(k), which never decreases.
+	if direction < 0 {
+		lo, hi = 1 - size, 1
+	}
+
+	first := sort.Search(steps + 1, func(k int) bool { return direction * f(k) >= lo })
+	end := sort.Search(steps + 1, func(k int) bool { return direction * f(k) >= hi })
+
+	return first, end - 1
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -1,9 +1,10 @@
 package electronbridge
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -37,6 +38,8 @@ type mouse_query struct {
 
 var OUT_msg_chan = make(chan []byte)
 var ERR_msg_chan = make(chan []byte)
+var flush_chan = make(chan chan bool)
+var printer_target_chan = make(chan io.Writer)
 
 var key_down_chan = make(chan string)
 var key_up_chan = make(chan string)
@@ -54,8 +57,15 @@ var mouse_xy_query = make(chan chan MouseLocation)
 var quit_chan = make(chan bool)
 var quit_query_chan = make(chan chan bool)
 
+var quit_handler func() bool
+var quit_handler_mutex sync.Mutex
+var quit_request_mutex sync.Mutex
+
 var cmd_chan = make(chan string)
 var cmd_query_chan = make(chan chan string)
+var dispatch_query_chan = make(chan chan []string)
+
+var incoming_line_chan = make(chan []byte)
 
 // ----------------------------------------------------------
 
@@ -114,30 +124,104 @@ type outgoing_msg struct {
 // ----------------------------------------------------------
 
 func init() {
-	go printer()
-	go listener()
-	go key_hub()
-	go mouse_click_hub()
-	go mouse_location_hub()
-	go quit_hub()
-	go command_hub()
+	go_safely(printer)
+	go_safely(stdin_reader)
+	go_safely(listener)
+	go_safely(recorder_hub)
+	go_safely(key_hub)
+	go_safely(mouse_click_hub)
+	go_safely(mouse_location_hub)
+	go_safely(quit_hub)
+	go_safely(command_hub)
 }
 
 // ----------------------------------------------------------
 
 func printer() {
+
+	var out io.Writer = os.Stdout		// Changed by Launch() to a pipe to the Electron process.
+
 	for {
 		select {
 		case s := <- OUT_msg_chan:
-			os.Stdout.Write(s)
+			out.Write(s)
 		case s := <- ERR_msg_chan:
 			os.Stderr.Write(s)
+			if out != os.Stdout {
+				out.Write(stderr_msg(s))	// Electron isn't reading our stderr, so it gets a copy this way.
+			}
+		case out = <- printer_target_chan:
+			// no other action
+		case response_chan := <- flush_chan:
+			response_chan <- true		// Everything sent before the flush request has been written.
 		}
 	}
 }
 
+func flush_output() {
+	response_chan := make(chan bool)
+	flush_chan <- response_chan
+	<- response_chan
+}
+
 // ----------------------------------------------------------
 
+func stdin_reader() {
+	frontend_reader(os.Stdin, true)
+}
+
+func stdin_is_frontend() bool {
+
+	// Whether stdin is the pipe from Electron, i.e. whether its EOF means the frontend is gone.
+	// In the other modes it's the keyboard, or nothing in particular.
+
+	return !terminal_active() && !launched() && !browser_active() && !replaying()
+}
+
+func frontend_reader(r io.Reader, is_stdin bool) {
+
+	// Lines from the frontend are passed to listener() via a channel, so that
+	// something else (see record.go) can feed it instead. In terminal mode,
+	// stdin is the keyboard and the raw bytes go to terminal.go instead.
+	// After Launch(), the frontend is the Electron process' stdout, not our stdin.
+
+	var pending []byte
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+
+		if n > 0 {
+
+			if is_stdin && terminal_active() {
+				terminal_input(append([]byte(nil), buf[:n]...))
+				continue
+			}
+
+			pending = append(pending, buf[:n]...)
+
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				line := pending[:i]
+				pending = pending[i + 1:]
+				if !replaying() && !(is_stdin && launched()) {
+					incoming_line_chan <- append([]byte(nil), line...)
+				}
+			}
+		}
+
+		if err != nil {
+			if !is_stdin || stdin_is_frontend() {
+				quit_chan <- true		// The frontend has gone away.
+			}
+			return
+		}
+	}
+}
+
 func listener() {
 
 	type incoming_msg_content struct {		// Used for all incoming message types. Not every field will be needed.
@@ -149,6 +233,9 @@ func listener() {
 		Key				string						`json:"key"`
 		Cmd				string						`json:"cmd"`
 		AckMessage		string						`json:"ackmessage"`
+		Id				string						`json:"id"`
+		Result			json.RawMessage				`json:"result"`
+		Checked			bool						`json:"checked"`
 	}
 
 	type incoming_msg struct {
@@ -158,24 +245,24 @@ func listener() {
 
 	// ----------------------------------
 
-	scanner := bufio.NewScanner(os.Stdin)
-
 	for {
-		scanner.Scan()
+		line := <- incoming_line_chan
 
-		// Logf("%v", scanner.Text())
+		// Logf("%v", string(line))
 
-		if strings.TrimSpace(scanner.Text()) == "" {
+		if strings.TrimSpace(string(line)) == "" {
 			continue
 		}
 
 		var msg incoming_msg
 
-		err := json.Unmarshal(scanner.Bytes(), &msg)
+		err := json.Unmarshal(line, &msg)
 		if err != nil {
 			continue
 		}
 
+		record(RECORD_IN, line)
+
 		if msg.Type == "key" {
 			if msg.Content.Down {
 				key_down_chan <- msg.Content.Key
@@ -202,31 +289,46 @@ func listener() {
 			quit_chan <- true
 		}
 
+		if msg.Type == "quitrequest" {
+			go_safely(handle_quit_request)		// Not on this goroutine, since the handler may need listener() to get answers.
+		}
+
 		if msg.Type == "cmd" {
+			menu_clicked(msg.Content.Cmd, msg.Content.Checked)
 			cmd_chan <- msg.Content.Cmd
 		}
 
 		if msg.Type == "ack" {
+			handle_ack(msg.Content.AckMessage)
+		}
 
-			// We got an ack, the content of which is some unique string. Look it up in our map of acks,
-			// and retrieve the channel down which we are supposed to send true.
-
-			pending_acks_mutex.Lock()
-			ch := pending_acks[msg.Content.AckMessage]
-			delete(pending_acks, msg.Content.AckMessage)
-			pending_acks_mutex.Unlock()
-
-			if ch != nil {
-				go ack_sender(ch)	// Spin up a new goroutine so we don't deadlock even if the ack-requester gave up waiting. Also, this can panic/recover.
-			} else {
-				Logf("listener: got ack '%s' but no channel existed to receive it", msg.Content.AckMessage)
-			}
+		if msg.Type == "response" {
+			handle_response(msg.Content.Id, msg.Content.Result)
 		}
 	}
 }
 
 // ----------------------------------------------------------
 
+func handle_ack(ack_message string) {
+
+	// We got an ack, the content of which is some unique string. Look it up in our map of acks,
+	// and retrieve the channel down which we are supposed to send true.
+
+	pending_acks_mutex.Lock()
+	ch := pending_acks[ack_message]
+	delete(pending_acks, ack_message)
+	pending_acks_mutex.Unlock()
+
+	if ch != nil {
+		go_safely(func() {	// Spin up a new goroutine so we don't deadlock even if the ack-requester gave up waiting. Also, this can panic/recover.
+			ack_sender(ch)
+		})
+	} else {
+		Logf("listener: got ack '%s' but no channel existed to receive it", ack_message)
+	}
+}
+
 func ack_sender(ch chan bool) {
 
 	defer func() {
@@ -418,16 +520,61 @@ func WeShouldQuit() bool {
 	return <- response_chan
 }
 
+func Quit() {
+
+	// Makes the frontend exit right away, so do any saving first.
+	// WeShouldQuit() will return true from now on.
+
+	quit_chan <- true
+	send_command_and_content("quit", nil)
+	flush_output()
+}
+
+func OnQuitRequested(f func() bool) {
+
+	// Once a handler is set, the frontend no longer exits by itself when the user closes the last
+	// window or picks Quit from the menu; it asks us instead. The handler is called on its own
+	// goroutine (so it may use Confirm() and the like) and returns true to go ahead, in which
+	// case Quit() is called for it, or false to stay open. Setting a handler implies AllowQuit().
+
+	quit_handler_mutex.Lock()
+	quit_handler = f
+	quit_handler_mutex.Unlock()
+
+	send_command_and_content("quithandler", f != nil)
+}
+
+func handle_quit_request() {
+
+	quit_request_mutex.Lock()			// One at a time, in case the user asks twice.
+	defer quit_request_mutex.Unlock()
+
+	quit_handler_mutex.Lock()
+	f := quit_handler
+	quit_handler_mutex.Unlock()
+
+	if f == nil || f() {
+		Quit()
+	} else {
+		send_command_and_content("quitvetoed", nil)
+	}
+}
+
 // ----------------------------------------------------------
 
 func command_hub() {
 
 	var queue []string
+	var handler_queue []string		// Commands with handlers, waiting for DispatchPending()
 
 	for {
 		select {
 		case cmd := <- cmd_chan:
-			queue = append(queue, cmd)
+			if command_handler(cmd) != nil {
+				handler_queue = append(handler_queue, cmd)
+			} else {
+				queue = append(queue, cmd)
+			}
 		case response_chan := <- cmd_query_chan:
 			if len(queue) == 0 {
 				response_chan <- ""
@@ -435,18 +582,34 @@ func command_hub() {
 				response_chan <- queue[0]
 				queue = queue[1:]
 			}
+		case response_chan := <- dispatch_query_chan:
+			response_chan <- handler_queue
+			handler_queue = nil
 		}
 	}
 }
 
 func RegisterCommand(s string, accel string) {
 
+	// The label doubles as the command's id. Conflicts are only logged; see RegisterCommandFunc().
+
+	err := claim_command(s, accel)
+	if err != nil {
+		Logf("RegisterCommand(): %v", err)
+	}
+
+	send_register(s, s, accel)
+}
+
+func send_register(id, label, accel string) {
+
 	type item struct {
+		Id				string		`json:"id"`
 		Label			string		`json:"label"`
 		Accelerator		string		`json:"accelerator"`
 	}
 
-	send_command_and_content("register", item{s, accel})
+	send_command_and_content("register", item{id, label, accel})
 }
 
 func RegisterSeparator() {
@@ -470,6 +633,7 @@ func GetCommand() (string, error) {
 // ----------------------------------------------------------
 
 func BuildMenu() {
+	send_menus_if_dirty()
 	send_command_and_content("buildmenu", nil)
 }
 
@@ -491,6 +655,18 @@ func send_command_and_content(command string, content interface{}) {
 		panic("Failed to Marshal")
 	}
 
+	record(RECORD_OUT, b)
+
+	if terminal_active() {
+		terminal_handle(command, content)
+		return
+	}
+
+	if browser_active() {
+		browser_send(b)
+		return
+	}
+
 	b = append(b, '\n')
 	OUT_msg_chan <- b
 }
@@ -517,6 +693,9 @@ func Logf(format_string string, args ...interface{}) {
 		msg += "\n"
 	}
 
+	b, _ := json.Marshal(strings.TrimSuffix(msg, "\n"))
+	record(RECORD_LOG, b)
+
 	ERR_msg_chan <- []byte(msg)
 }
 
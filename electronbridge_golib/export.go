@@ -0,0 +1,150 @@
+package electronbridge
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image/color"
+	"strings"
+)
+
+// Text serialisations of what a grid holds (for a GridWindow, not necessarily what has been flipped),
+// for golden files, bug reports and the dev log. Colours are looked up in the palette.
+
+func (b *CellBuffer) ExportText() string {
+
+	// One line per row, each ending in "\n". Colours are ignored.
+
+	b.Mutex.Lock()
+	s := b.snapshot()
+	b.Mutex.Unlock()
+
+	return s.text()
+}
+
+func (b *CellBuffer) ExportANSI() string {
+
+	// As ExportText(), but with 24-bit colour escape codes, suitable for printing to a terminal.
+
+	b.Mutex.Lock()
+	s := b.snapshot()
+	b.Mutex.Unlock()
+
+	return s.ansi()
+}
+
+func (b *CellBuffer) ExportHTML() string {
+
+	// A <pre> element with inline styles, so it can be pasted anywhere without a stylesheet.
+
+	b.Mutex.Lock()
+	s := b.snapshot()
+	b.Mutex.Unlock()
+
+	return s.html()
+}
+
+// ----------------------------------------------------------
+
+func (s *grid_snapshot) text() string {
+
+	var buf bytes.Buffer
+
+	for y := 0; y < s.Height; y++ {
+		for _, char := range s.Chars[y * s.Width:(y + 1) * s.Width] {
+			if char != WIDE_CONTINUATION {
+				buf.WriteString(char)
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
+
+func (s *grid_snapshot) ansi() string {
+
+	var buf bytes.Buffer
+
+	for y := 0; y < s.Height; y++ {
+		a, b := y * s.Width, (y + 1) * s.Width
+		write_ansi_row(&buf, s.Palette, s.Chars[a:b], s.Colours[a:b], s.Backgrounds[a:b], s.ColourRGBs[a:b], s.BackgroundRGBs[a:b], s.Attrs[a:b], true)
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
+
+func (s *grid_snapshot) html() string {
+
+	// Each run of cells with the same colours in a row becomes one span.
+
+	var buf bytes.Buffer
+
+	buf.WriteString(`<pre style="font-family: monospace; line-height: 1; margin: 0;">`)
+
+	for y := 0; y < s.Height; y++ {
+
+		x := 0
+
+		for x < s.Width {
+
+			fg, bg, attrs := s.colour(x, y), s.background(x, y), s.Attrs[y * s.Width + x]
+
+			var run []string
+
+			for x < s.Width && s.colour(x, y) == fg && s.background(x, y) == bg && s.Attrs[y * s.Width + x] == attrs {
+				if s.Chars[y * s.Width + x] != WIDE_CONTINUATION {
+					run = append(run, s.Chars[y * s.Width + x])
+				}
+				x++
+			}
+
+			shown_fg, shown_bg := apply_colour_attrs(fg, bg, attrs)
+
+			fmt.Fprintf(&buf, `<span style="color: %s; background-color: %s;%s">%s</span>`,
+				css_hex(shown_fg), css_hex(shown_bg), css_attrs(attrs), html.EscapeString(strings.Join(run, "")))
+		}
+
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("</pre>\n")
+
+	return buf.String()
+}
+
+func css_attrs(attrs uint8) string {
+
+	// Extra inline style for the attributes. Blink needs a stylesheet, so is left out.
+
+	ret := ""
+
+	if attrs & ATTR_BOLD != 0 {
+		ret += " font-weight: bold;"
+	}
+
+	if attrs & ATTR_ITALIC != 0 {
+		ret += " font-style: italic;"
+	}
+
+	var decorations []string
+
+	if attrs & ATTR_UNDERLINE != 0 {
+		decorations = append(decorations, "underline")
+	}
+
+	if attrs & ATTR_STRIKETHROUGH != 0 {
+		decorations = append(decorations, "line-through")
+	}
+
+	if len(decorations) > 0 {
+		ret += " text-decoration: " + strings.Join(decorations, " ") + ";"
+	}
+
+	return ret
+}
+
+func css_hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,350 @@
+package gridtest
+
+// Golden file tests for GridWindow contents. In a test:
+//
+//		gridtest.Golden(t, w, "testdata/title_screen.golden")
+//
+// compares the window against the file, and reports any cells that differ. Run the tests with
+// -update (go test ./... -update) to (re)write the golden files instead. Setting the environment
+// variable GRIDTEST_UPDATE=1 does the same, for when flags are awkward to pass.
+//
+// This package registers the -update flag, so a test package using it shouldn't define its own;
+// it can read ours with flag.Lookup("update") if it has golden files of its own.
+//
+// The file is plain text, so it can be read and reviewed. After a header giving the size come
+// three sections of one line per row, then a list of the cells that have anything more (truecolour,
+// attributes, a tile), one per line:
+//
+//		gridtest 5x1
+//		-- chars
+//		Hello
+//		-- colours
+//		ww#ww
+//		-- backgrounds
+//		00000
+//		-- cells
+//		2,0 fg=#ff8000 attrs=bold+underline
+//
+// Sections are found by position, not by their marker lines, so row data can't be mistaken for
+// a marker whatever it contains.
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	electron ".."
+)
+
+const UPDATE_ENV = "GRIDTEST_UPDATE"
+
+const MAX_REPORTED_CELLS = 20
+
+var sections = []string{"chars", "colours", "backgrounds"}
+
+var attr_names = []struct {
+	attr			uint8
+	name			string
+}{
+	{electron.ATTR_BOLD, "bold"},
+	{electron.ATTR_ITALIC, "italic"},
+	{electron.ATTR_UNDERLINE, "underline"},
+	{electron.ATTR_STRIKETHROUGH, "strikethrough"},
+	{electron.ATTR_REVERSE, "reverse"},
+	{electron.ATTR_BLINK, "blink"},
+	{electron.ATTR_DIM, "dim"},
+}
+
+// ----------------------------------------------------------
+
+func init() {
+	if flag.Lookup("update") == nil {		// Whoever registered it first meant the same thing.
+		flag.Bool("update", false, "rewrite golden files instead of comparing against them")
+	}
+}
+
+func updating() bool {
+	if f := flag.Lookup("update"); f != nil && f.Value.String() == "true" {
+		return true
+	}
+	return os.Getenv(UPDATE_ENV) != ""
+}
+
+func Format(w *electron.GridWindow) string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "gridtest %dx%d\n", w.Width, w.Height)
+
+	for _, section := range sections {
+
+		fmt.Fprintf(&b, "-- %s\n", section)
+
+		for y := 0; y < w.Height; y++ {
+			for x := 0; x < w.Width; x++ {
+				spot := w.Get(x, y)
+				switch section {
+				case "chars":
+					b.WriteString(spot.Char)
+				case "colours":
+					b.WriteString(spot.Colour)
+				case "backgrounds":
+					b.WriteString(spot.Background)
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("-- cells\n")
+
+	for y := 0; y < w.Height; y++ {
+		for x := 0; x < w.Width; x++ {
+			extra := format_extra(w.Get(x, y))
+			if extra != "" {
+				fmt.Fprintf(&b, "%d,%d %s\n", x, y, extra)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func format_extra(spot electron.Spot) string {
+
+	// Whatever the three main sections don't show, or "" if there's nothing.
+
+	var parts []string
+
+	if spot.Colour == electron.RGB_KEY {
+		parts = append(parts, "fg=" + hex(spot.ColourRGB))
+	}
+
+	if spot.Background == electron.RGB_KEY {
+		parts = append(parts, "bg=" + hex(spot.BackgroundRGB))
+	}
+
+	if spot.Attrs != 0 {
+		var names []string
+		rest := spot.Attrs
+		for _, a := range attr_names {
+			if spot.Attrs & a.attr != 0 {
+				names = append(names, a.name)
+				rest &^= a.attr
+			}
+		}
+		if rest != 0 {
+			names = append(names, strconv.Itoa(int(rest)))
+		}
+		parts = append(parts, "attrs=" + strings.Join(names, "+"))
+	}
+
+	if spot.Tile != electron.NO_TILE {
+		parts = append(parts, "tile=" + strconv.Itoa(spot.Tile))
+		if spot.Tint.A != 0 {
+			parts = append(parts, "tint=" + hex(spot.Tint))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func Golden(t testing.TB, w *electron.GridWindow, filename string) {
+
+	t.Helper()
+
+	got := Format(w)
+
+	if updating() {
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte(got), 0644)
+		}
+		if err != nil {
+			t.Fatalf("gridtest: %v", err)
+		}
+		return
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("gridtest: %v (run with -update to create it)", err)
+		return
+	}
+
+	want := strings.Replace(string(raw), "\r\n", "\n", -1)
+
+	if got == want {
+		return
+	}
+
+	t.Errorf("gridtest: %s does not match (run with -update to accept the new output)\n%s", filename, Diff(want, got))
+}
+
+// ----------------------------------------------------------
+
+type cell struct {
+	char			string
+	colour			string
+	background		string
+	extra			string
+}
+
+func parse(s string) (grid [][]cell, err error) {
+
+	// Returns the grid as rows of cells.
+
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+
+	var width, height int
+
+	_, err = fmt.Sscanf(lines[0], "gridtest %dx%d", &width, &height)
+	if err != nil || width < 0 || height < 0 {
+		return nil, fmt.Errorf("bad header %q", lines[0])
+	}
+
+	// The marker of each section is followed by exactly height rows.
+
+	rows := make(map[string][]string)
+	n := 1
+
+	for _, section := range append(sections, "cells") {
+
+		if n >= len(lines) || lines[n] != "-- " + section {
+			return nil, fmt.Errorf("line %d: expected the '%s' section", n + 1, section)
+		}
+		n++
+
+		if section == "cells" {
+			rows[section] = lines[n:]
+			break
+		}
+
+		if n + height > len(lines) {
+			return nil, fmt.Errorf("section '%s' has fewer than %d rows", section, height)
+		}
+		rows[section] = lines[n:n + height]
+		n += height
+	}
+
+	for y := 0; y < height; y++ {
+
+		c, f, b := []rune(rows["chars"][y]), []rune(rows["colours"][y]), []rune(rows["backgrounds"][y])
+
+		if len(c) != width || len(f) != width || len(b) != width {
+			return nil, fmt.Errorf("row %d is not %d cells wide in every section", y, width)
+		}
+
+		var row []cell
+		for x := range c {
+			row = append(row, cell{char: string(c[x]), colour: string(f[x]), background: string(b[x])})
+		}
+		grid = append(grid, row)
+	}
+
+	for _, line := range rows["cells"] {
+
+		var x, y int
+
+		fields := strings.SplitN(line, " ", 2)
+
+		_, err = fmt.Sscanf(fields[0], "%d,%d", &x, &y)
+		if err != nil || len(fields) != 2 || x < 0 || x >= width || y < 0 || y >= height {
+			return nil, fmt.Errorf("bad cell line %q", line)
+		}
+
+		grid[y][x].extra = fields[1]
+	}
+
+	return grid, nil
+}
+
+func Diff(want, got string) string {
+
+	// A readable, cell-by-cell account of the differences between two outputs of Format().
+
+	want_grid, err := parse(want)
+	if err != nil {
+		return fmt.Sprintf("bad golden file: %v", err)
+	}
+
+	got_grid, err := parse(got)
+	if err != nil {
+		return fmt.Sprintf("bad output: %v", err)
+	}
+
+	var b strings.Builder
+
+	if len(want_grid) != len(got_grid) || (len(want_grid) > 0 && len(want_grid[0]) != len(got_grid[0])) {
+		fmt.Fprintf(&b, "size: want %s, got %s\n", size_string(want_grid), size_string(got_grid))
+	}
+
+	reported, total := 0, 0
+
+	for y := 0; y < len(want_grid) && y < len(got_grid); y++ {
+		for x := 0; x < len(want_grid[y]) && x < len(got_grid[y]); x++ {
+
+			w, g := want_grid[y][x], got_grid[y][x]
+			if w == g {
+				continue
+			}
+
+			total++
+			if reported < MAX_REPORTED_CELLS {
+				reported++
+				fmt.Fprintf(&b, "  (%d, %d): want %s, got %s\n", x, y, cell_string(w), cell_string(g))
+			}
+		}
+	}
+
+	if total > reported {
+		fmt.Fprintf(&b, "  ...and %d more cells\n", total - reported)
+	}
+
+	b.WriteString("want:\n")
+	b.WriteString(indent(want_grid))
+	b.WriteString("got:\n")
+	b.WriteString(indent(got_grid))
+
+	return b.String()
+}
+
+func cell_string(c cell) string {
+	s := fmt.Sprintf("%q colour %q background %q", c.char, c.colour, c.background)
+	if c.extra != "" {
+		s += " " + c.extra
+	}
+	return s
+}
+
+func size_string(grid [][]cell) string {
+	if len(grid) == 0 {
+		return "0x0"
+	}
+	return fmt.Sprintf("%dx%d", len(grid[0]), len(grid))
+}
+
+func indent(grid [][]cell) string {
+
+	var b strings.Builder
+
+	for _, row := range grid {
+		b.WriteString("  |")
+		for _, c := range row {
+			b.WriteString(c.char)
+		}
+		b.WriteString("|\n")
+	}
+
+	return b.String()
+}
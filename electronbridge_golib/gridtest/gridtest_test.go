@@ -0,0 +1,127 @@
+package gridtest
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	electron ".."
+)
+
+func new_window(width, height int) *electron.GridWindow {
+	return electron.NewGridWindowWithOptions(electron.GridWindowOptions{Name: "gridtest", Width: width, Height: height, BoxWidth: 8, BoxHeight: 8})
+}
+
+func new_tileset(t *testing.T) *electron.Tileset {
+
+	filename := filepath.Join(t.TempDir(), "tiles.png")
+
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = png.Encode(file, image.NewRGBA(image.Rect(0, 0, 16, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := electron.RegisterTileset(filename, 8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestFormatCells(t *testing.T) {
+
+	ts := new_tileset(t)
+
+	w := new_window(5, 2)
+	w.Print(0, 0, "Hello", "w", "0")
+	w.SetRGB(1, 1, "x", color.RGBA{255, 128, 0, 255}, color.RGBA{0, 0, 16, 255})
+	w.SetAttrs(2, 1, electron.ATTR_BOLD | electron.ATTR_UNDERLINE)
+	w.SetTile(3, 1, ts.Tile(1), color.RGBA{255, 255, 255, 255})
+
+	got := Format(w)
+
+	for _, line := range []string{
+		"gridtest 5x2\n",
+		"1,1 fg=#ff8000 bg=#000010\n",
+		"2,1 attrs=bold+underline\n",
+		"3,1 tile=" + strconv.Itoa(ts.Tile(1)) + " tint=#ffffff\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("output lacks %q:\n%s", line, got)
+		}
+	}
+
+	_, err := parse(got)
+	if err != nil {
+		t.Errorf("parse: %v", err)
+	}
+}
+
+func TestMarkerAsRowData(t *testing.T) {
+
+	// Row data that looks like a section marker is still row data.
+
+	w := new_window(8, 2)
+	w.Print(0, 0, "-- chars", "w", "0")
+	w.Print(0, 1, "-- cells", "w", "0")
+
+	grid, err := parse(Format(w))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if len(grid) != 2 || grid[1][3].char != "c" {
+		t.Errorf("wrong grid: %v", grid)
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	w := new_window(4, 1)
+	w.Print(0, 0, "abcd", "w", "0")
+	want := Format(w)
+
+	w.Set(1, 0, "B", "w", "0")
+	w.SetAttrs(3, 0, electron.ATTR_ITALIC)
+	d := Diff(want, Format(w))
+
+	if !strings.Contains(d, `(1, 0): want "b"`) || !strings.Contains(d, "(3, 0):") || !strings.Contains(d, "attrs=italic") {
+		t.Errorf("diff doesn't report the changed cells:\n%s", d)
+	}
+	if strings.Contains(d, "(0, 0)") || strings.Contains(d, "(2, 0)") {
+		t.Errorf("diff reports unchanged cells:\n%s", d)
+	}
+}
+
+func TestGolden(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "testdata", "window.golden")
+
+	w := new_window(3, 1)
+	w.Print(0, 0, "abc", "w", "0")
+
+	flag.Set("update", "true")
+	Golden(t, w, filename)
+	flag.Set("update", "false")
+
+	Golden(t, w, filename)
+
+	t.Setenv(UPDATE_ENV, "1")
+	w.Print(0, 0, "xyz", "w", "0")
+	Golden(t, w, filename)
+	t.Setenv(UPDATE_ENV, "")
+
+	Golden(t, w, filename)
+}
@@ -1,51 +1,35 @@
 package electronbridge
 
 import (
-	"encoding/json"
-	"strings"
-	"sync"
+	"context"
+	"errors"
+	"fmt"
 	"time"
-	"unicode/utf8"
 )
 
 const (
-	CLEAR_CHAR = " "
-	CLEAR_COLOUR = "w"
-	CLEAR_BACKGROUND = "0"
+	RENDERER_TABLE = "table"			// The default: one table cell per grid cell. Fine for small grids.
+	RENDERER_CANVAS = "canvas"			// Draws on a canvas, redrawing only what changed. Better for big grids.
 )
 
-type Spot struct {
-	Char			string
-	Colour			string
-	Background		string
-}
-
-type string_slice []string	// For convenience, things that should really be runes are stored as strings
-
-func (s string_slice) MarshalJSON() ([]byte, error) {	// Marshalling them means concatenation
-	str := strings.Join(s, "")
-	return json.Marshal(str)
-}
-
 type GridWindow struct {
 	Uid					int							`json:"uid"`
-	Width				int							`json:"width"`
-	Height				int							`json:"height"`
-	Chars				string_slice				`json:"chars"`
-	Colours				string_slice				`json:"colours"`
-	Backgrounds			string_slice				`json:"backgrounds"`
+	CellBuffer
 	CameraX				int							`json:"camerax"`		// Only used to keep animations in alignment with the world
 	CameraY				int							`json:"cameray"`		// Only used to keep animations in alignment with the world
 	Title				string						`json:"title"`
 	AckRequired			string						`json:"ackrequired"`	// Updated each flip (maybe set to "" though)
 
 	BackendCanDrop		bool						`json:"-"`
-	Mutex				sync.Mutex					`json:"-"`
 	LastSend			time.Time					`json:"-"`
 	CallCount			int64						`json:"-"`
 	FlipLatersActive	int							`json:"-"`
 	FramesDropped		int							`json:"-"`
 	NextDropWarning		int							`json:"-"`
+	FlipRecorders		[]*FlipRecorder				`json:"-"`
+	Layers				[]*Layer					`json:"-"`
+	PendingScrolls		[]scroll_op					`json:"-"`		// Since the last frame sent (see scroll.go)
+	SentFrame			*grid_snapshot				`json:"-"`		// Only kept once the window has scrolled
 }
 
 func (self *GridWindow) GetUID() int {
@@ -65,6 +49,27 @@ type new_grid_win_msg struct {
 	FontPercent			int							`json:"fontpercent"`
 	StartHidden			bool						`json:"starthidden"`
 	Resizable			bool						`json:"resizable"`
+	NoMenu				bool						`json:"nomenu"`
+	Renderer			string						`json:"renderer"`
+	Palette				map[string]string			`json:"palette,omitempty"`	// Only if set from Go
+	Tilesets			[]tileset_msg				`json:"tilesets,omitempty"`	// Registered so far
+}
+
+type GridWindowOptions struct {
+	Name				string
+	Page				string
+	Width				int
+	Height				int
+	BoxWidth			int
+	BoxHeight			int
+	AnimationXOffset	int
+	AnimationYOffset	int
+	FontPercent			int
+	BackendCanDrop		bool
+	StartHidden			bool
+	Resizable			bool
+	NoMenu				bool						// No menu bar at all (see also SetWindowMenu)
+	Renderer			string						// RENDERER_TABLE (or "") or RENDERER_CANVAS
 }
 
 func NewGridWindow(
@@ -72,27 +77,9 @@ func NewGridWindow(
 			width, height, boxwidth, boxheight, animation_x_offset, animation_y_offset, fontpercent int,
 			backend_can_drop, starthidden, resizable bool) *GridWindow {
 
-	uid := id_maker.next()
-
-	w := GridWindow{Uid: uid, Width: width, Height: height}
-
-	w.Chars = make([]string, width * height)
-	w.Colours = make([]string, width * height)
-	w.Backgrounds = make([]string, width * height)
-
-	w.Title = name
-
-	w.BackendCanDrop = backend_can_drop
-	w.NextDropWarning = 1
-
-	w.Clear()
-
-	// Create the message to send to the server...
-
-	c := new_grid_win_msg{
+	return NewGridWindowWithOptions(GridWindowOptions{
 		Name: name,
 		Page: page,
-		Uid: uid,
 		Width: width,
 		Height: height,
 		BoxWidth: boxwidth,
@@ -100,68 +87,82 @@ func NewGridWindow(
 		AnimationXOffset: animation_x_offset,
 		AnimationYOffset: animation_y_offset,
 		FontPercent: fontpercent,
+		BackendCanDrop: backend_can_drop,
 		StartHidden: starthidden,
 		Resizable: resizable,
-	}
-	send_command_and_content("new", c)
-
-	return &w
+	})
 }
 
-func (w *GridWindow) Set(x, y int, char, colour, background string) {
-
-	w.Mutex.Lock()
-	defer w.Mutex.Unlock()
-
-	if utf8.RuneCountInString(char) != 1 {
-		panic("GridWindow.Set(): utf8.RuneCountInString(char) != 1")
-	}
+func NewGridWindowWithOptions(opts GridWindowOptions) *GridWindow {
 
-	if utf8.RuneCountInString(colour) != 1 {
-		panic("GridWindow.Set(): utf8.RuneCountInString(colour) != 1")
+	if opts.Renderer != "" && opts.Renderer != RENDERER_TABLE && opts.Renderer != RENDERER_CANVAS {
+		panic(fmt.Sprintf("NewGridWindowWithOptions(): unknown renderer \"%s\"", opts.Renderer))
 	}
 
-	if utf8.RuneCountInString(background) != 1 {
-		panic("GridWindow.Set(): utf8.RuneCountInString(background) != 1")
-	}
+	uid := id_maker.next()
 
-	index := y * w.Width + x
-	if index < 0 || index >= len(w.Chars) || x < 0 || x >= w.Width || y < 0 || y >= w.Height {
-		return
-	}
+	w := GridWindow{Uid: uid}
+	w.init(opts.Width, opts.Height)
+	w.OwnerUid = uid
 
-	w.Chars[index] = char
-	w.Colours[index] = colour
-	w.Backgrounds[index] = background
-}
+	w.Title = opts.Name
 
-func (w *GridWindow) Get(x, y int) Spot {
+	w.BackendCanDrop = opts.BackendCanDrop
+	w.NextDropWarning = 1
 
-	w.Mutex.Lock()
-	defer w.Mutex.Unlock()
+	// Create the message to send to the server...
 
-	index := y * w.Width + x
-	if index < 0 || index >= len(w.Chars) || x < 0 || x >= w.Width || y < 0 || y >= w.Height {
-		return Spot{Char: CLEAR_CHAR, Colour: CLEAR_COLOUR, Background: CLEAR_BACKGROUND}
+	c := new_grid_win_msg{
+		Name: opts.Name,
+		Page: opts.Page,
+		Uid: uid,
+		Width: opts.Width,
+		Height: opts.Height,
+		BoxWidth: opts.BoxWidth,
+		BoxHeight: opts.BoxHeight,
+		AnimationXOffset: opts.AnimationXOffset,
+		AnimationYOffset: opts.AnimationYOffset,
+		FontPercent: opts.FontPercent,
+		StartHidden: opts.StartHidden,
+		Resizable: opts.Resizable,
+		NoMenu: opts.NoMenu,
+		Renderer: opts.Renderer,
 	}
 
-	char := w.Chars[index]
-	colour := w.Colours[index]
-	background := w.Backgrounds[index]
+	go_palette_mutex.Lock()
+	c.Palette = go_palette
+	go_palette_mutex.Unlock()
+
+	c.Tilesets = tileset_messages()
 
-	return Spot{Char: char, Colour: colour, Background: background}
+	send_command_and_content("new", c)
+
+	return &w
 }
 
-func (w *GridWindow) Clear() {
+func (w *GridWindow) SetPalette(pal map[rune]string) error {
+
+	// Gives this grid its own palette, which takes precedence over the global one. A nil map
+	// goes back to using the global palette.
 
 	w.Mutex.Lock()
 	defer w.Mutex.Unlock()
 
-	for n := 0; n < len(w.Chars); n++ {
-		w.Chars[n] = CLEAR_CHAR
-		w.Colours[n] = CLEAR_COLOUR
-		w.Backgrounds[n] = CLEAR_BACKGROUND
+	var converted map[string]string
+
+	if pal != nil {
+		var err error
+		converted, err = convert_palette(pal)
+		if err != nil {
+			return fmt.Errorf("GridWindow.SetPalette(): %v", err)
+		}
 	}
+
+	w.Palette = converted
+	w.UnknownKeys = nil
+
+	send_command_and_content("palette", palette_msg{Uid: w.Uid, Palette: converted})
+	return nil
 }
 
 func (w *GridWindow) SetTitle(s string) {
@@ -202,7 +203,10 @@ func (w *GridWindow) Flip(ack_channel chan bool) {
 
 			if w.FlipLatersActive < 100 {		// I don't want a zillion of these goroutines running.
 				w.FlipLatersActive++
-				go w.FlipLater(w.CallCount)
+				count := w.CallCount
+				go_safely(func() {
+					w.FlipLater(count)
+				})
 			}
 			return
 		}
@@ -231,7 +235,32 @@ func (w *GridWindow) Flip(ack_channel chan bool) {
 		}()
 	}
 
-	send_command_and_content("update", w)
+	w.send_frame()
+}
+
+func (w *GridWindow) send_frame() {
+
+	// The caller holds w.Mutex.
+
+	frame := w.composite()
+
+	w.record_flip(frame)
+
+	if w.SentFrame == nil && len(w.PendingScrolls) == 0 {
+		send_command_and_content("update", frame)
+		return
+	}
+
+	s := frame.snapshot()
+
+	if msg := w.scroll_message(frame, s); msg != nil {
+		send_command_and_content("update", msg)
+	} else {
+		send_command_and_content("update", frame)
+	}
+
+	w.SentFrame = s
+	w.PendingScrolls = nil
 }
 
 func (w *GridWindow) FlipLater(call_count int64) {
@@ -247,7 +276,7 @@ func (w *GridWindow) FlipLater(call_count int64) {
 	if w.CallCount == call_count {		// Flip() was never called since the skip.
 		w.LastSend = time.Now()
 		w.AckRequired = ""
-		send_command_and_content("update", w)
+		w.send_frame()
 	}
 
 	w.FlipLatersActive--
@@ -265,3 +294,38 @@ func (w *GridWindow) FlipWithCamera(CameraX, CameraY int, ack_channel chan bool)
 	w.CameraX = CameraX
 	w.CameraY = CameraY
 }
+
+type context_menu_args struct {
+	Uid					int							`json:"uid"`
+	X					int							`json:"x"`
+	Y					int							`json:"y"`
+	Items				[]string					`json:"items"`
+}
+
+type context_menu_result struct {
+	Index				int							`json:"index"`
+}
+
+func (w *GridWindow) ShowContextMenu(ctx context.Context, x, y int, items []string) (int, error) {
+
+	// Pops up a native menu at cell x, y (usually in response to a MousePress with Button == 2)
+	// and returns the index into items of the one chosen. An item "-" is a separator. If the user
+	// dismisses the menu, returns -1 and ErrCancelled.
+
+	if len(items) == 0 {
+		return -1, errors.New("ShowContextMenu(): no items")
+	}
+
+	var result context_menu_result
+
+	err := request(ctx, "contextmenu", context_menu_args{Uid: w.Uid, X: x, Y: y, Items: items}, &result)
+	if err != nil {
+		return -1, err
+	}
+
+	if result.Index < 0 || result.Index >= len(items) || items[result.Index] == "-" {
+		return -1, ErrCancelled
+	}
+
+	return result.Index, nil
+}
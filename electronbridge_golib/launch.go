@@ -0,0 +1,146 @@
+package electronbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Normally main.js starts the Go app as its child. Launch() turns that around: the Go app starts
+// Electron (with this repo's main.js) as its own child and talks to it over the child's stdin and
+// stdout. main.js is told about this with the --electronbridge-attached flag.
+//
+// When the app was itself started by main.js (which sets ELECTRONBRIDGE_CHILD), Launch() does
+// nothing, so an app can call it unconditionally and work either way.
+
+const LAUNCH_CHILD_ENV = "ELECTRONBRIDGE_CHILD"
+const LAUNCH_ATTACHED_FLAG = "--electronbridge-attached"
+
+type LaunchOptions struct {
+	ElectronPath	string			// If "", try $ELECTRON_PATH, then node_modules/.bin under AppDir, then $PATH.
+	AppDir			string			// Directory with package.json; pages are also found relative to it. Default: working directory.
+	Args			[]string		// Extra arguments for Electron, e.g. os.Args[1:]
+	Env				[]string		// Extra environment variables ("KEY=value"), added to our own.
+}
+
+var launch_active bool
+var launch_mutex sync.Mutex
+
+func launched() bool {
+	launch_mutex.Lock()
+	defer launch_mutex.Unlock()
+	return launch_active
+}
+
+func Launch(opts LaunchOptions) error {
+
+	if os.Getenv(LAUNCH_CHILD_ENV) != "" {
+		return nil
+	}
+
+	launch_mutex.Lock()
+	defer launch_mutex.Unlock()
+
+	if launch_active {
+		return fmt.Errorf("Launch(): already launched")
+	}
+
+	app_dir := opts.AppDir
+	if app_dir == "" {
+		app_dir = "."
+	}
+
+	app_dir, err := filepath.Abs(app_dir)
+	if err != nil {
+		return fmt.Errorf("Launch(): %v", err)
+	}
+
+	electron_path, err := find_electron(opts.ElectronPath, app_dir)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{app_dir, LAUNCH_ATTACHED_FLAG}, opts.Args...)
+
+	cmd := exec.Command(electron_path, args...)
+	cmd.Dir = app_dir
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Stderr = os.Stderr
+
+	electron_stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Launch(): %v", err)
+	}
+
+	electron_stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Launch(): %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Launch(): %v", err)
+	}
+
+	launch_active = true
+	printer_target_chan <- electron_stdin
+
+	go_safely(func() {
+		frontend_reader(electron_stdout, false)
+	})
+
+	go_safely(func() {
+		err := cmd.Wait()		// When Electron exits, frontend_reader() gets EOF and sets WeShouldQuit().
+		if err != nil {
+			Logf("Launch(): Electron exited: %v", err)
+		} else {
+			Logf("Launch(): Electron exited")
+		}
+	})
+
+	return nil
+}
+
+func find_electron(electron_path, app_dir string) (string, error) {
+
+	if electron_path != "" {
+		return electron_path, nil
+	}
+
+	if env := os.Getenv("ELECTRON_PATH"); env != "" {
+		return env, nil
+	}
+
+	local := filepath.Join(app_dir, "node_modules", ".bin", "electron")
+	if runtime.GOOS == "windows" {
+		local += ".cmd"
+	}
+
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+
+	found, err := exec.LookPath("electron")
+	if err != nil {
+		return "", fmt.Errorf("Launch(): couldn't find Electron; set LaunchOptions.ElectronPath or $ELECTRON_PATH")
+	}
+
+	return found, nil
+}
+
+func stderr_msg(s []byte) []byte {
+
+	// Wraps a Logf() line as a message for main.js, which shows it in the dev log as if it had
+	// arrived on our stderr.
+
+	b, err := json.Marshal(outgoing_msg{Command: "stderr", Content: strings.TrimSuffix(string(s), "\n")})
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
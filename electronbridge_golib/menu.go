@@ -0,0 +1,293 @@
+package electronbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// A menu model kept in Go. Top-level menus made with AddMenu() appear in the menu bar between
+// the App menu (where RegisterCommand() items go) and the Windows menu. Every item that can be
+// clicked has an id, and clicking it puts the id on the same queue GetCommand() reads. Ids and
+// accelerators can't be shared with other items or registered commands; adding such an item fails.
+//
+// The whole model is sent by BuildMenu(), but only if its structure changed since last time.
+// Changes of state (enabled, checked, label) made with the SetMenu...() functions are sent
+// straight away and applied by the frontend without the app having to call BuildMenu() again.
+//
+// Radio items form a group with any radio items directly next to them, as in Electron.
+//
+// A window can have its own menu bar instead: make its menus with NewMenu() (which, unlike
+// AddMenu(), doesn't put them in the application menu bar) and pass them to SetWindowMenu().
+// Their items work like any others. On macOS there is only ever the application menu.
+
+const (
+	MENU_NORMAL = "normal"
+	MENU_CHECKBOX = "checkbox"
+	MENU_RADIO = "radio"
+	MENU_SEPARATOR = "separator"
+	MENU_SUBMENU = "submenu"
+)
+
+type Menu struct {
+	Label			string						`json:"label"`
+	Items			[]*MenuItem					`json:"items"`
+}
+
+type MenuItem struct {
+	Id				string						`json:"id"`
+	Label			string						`json:"label"`
+	Accelerator		string						`json:"accelerator"`
+	Type			string						`json:"type"`
+	Checked			bool						`json:"checked"`
+	Enabled			bool						`json:"enabled"`
+	Submenu			*Menu						`json:"submenu,omitempty"`
+
+	parent			*Menu
+}
+
+type menu_item_state struct {
+	Id				string						`json:"id"`
+	Label			string						`json:"label"`
+	Checked			bool						`json:"checked"`
+	Enabled			bool						`json:"enabled"`
+}
+
+var menu_mutex sync.Mutex
+var menu_bar []*Menu
+var menu_items = make(map[string]*MenuItem)		// id --> item
+var menu_dirty bool								// Structure changed since BuildMenu()
+
+// ----------------------------------------------------------
+
+func AddMenu(label string) *Menu {
+
+	menu_mutex.Lock()
+	defer menu_mutex.Unlock()
+
+	m := &Menu{Label: label}
+	menu_bar = append(menu_bar, m)
+	menu_dirty = true
+
+	return m
+}
+
+func NewMenu(label string) *Menu {
+	return &Menu{Label: label}
+}
+
+func (m *Menu) add(item *MenuItem) (*MenuItem, error) {
+
+	// If the id or accelerator is already taken, the item isn't added.
+
+	menu_mutex.Lock()
+	defer menu_mutex.Unlock()
+
+	if item.Id != "" {
+		err := claim_command(item.Id, item.Accelerator)
+		if err != nil {
+			return nil, fmt.Errorf("Menu %s: %v", m.Label, err)
+		}
+		menu_items[item.Id] = item
+	}
+
+	item.parent = m
+	m.Items = append(m.Items, item)
+	menu_dirty = true
+
+	return item, nil
+}
+
+func (m *Menu) AddCommand(id, label, accel string) (*MenuItem, error) {
+	return m.add(&MenuItem{Id: id, Label: label, Accelerator: accel, Type: MENU_NORMAL, Enabled: true})
+}
+
+func (m *Menu) AddCheckbox(id, label, accel string, checked bool) (*MenuItem, error) {
+	return m.add(&MenuItem{Id: id, Label: label, Accelerator: accel, Type: MENU_CHECKBOX, Checked: checked, Enabled: true})
+}
+
+func (m *Menu) AddRadio(id, label, accel string, checked bool) (*MenuItem, error) {
+	return m.add(&MenuItem{Id: id, Label: label, Accelerator: accel, Type: MENU_RADIO, Checked: checked, Enabled: true})
+}
+
+func (m *Menu) AddSeparator() {
+	m.add(&MenuItem{Type: MENU_SEPARATOR, Enabled: true})
+}
+
+func (m *Menu) AddSubmenu(label string) *Menu {
+	sub := &Menu{Label: label}
+	m.add(&MenuItem{Label: label, Type: MENU_SUBMENU, Enabled: true, Submenu: sub})
+	return sub
+}
+
+// ----------------------------------------------------------
+
+func SetMenuEnabled(id string, enabled bool) error {
+	return update_menu_item(id, func(item *MenuItem) []*MenuItem {
+		item.Enabled = enabled
+		return []*MenuItem{item}
+	})
+}
+
+func SetMenuLabel(id string, label string) error {
+	return update_menu_item(id, func(item *MenuItem) []*MenuItem {
+		item.Label = label
+		return []*MenuItem{item}
+	})
+}
+
+func SetMenuChecked(id string, checked bool) error {
+	return update_menu_item(id, func(item *MenuItem) []*MenuItem {
+		return set_checked(item, checked)
+	})
+}
+
+func MenuChecked(id string) bool {
+
+	menu_mutex.Lock()
+	defer menu_mutex.Unlock()
+
+	item := menu_items[id]
+	return item != nil && item.Checked
+}
+
+func update_menu_item(id string, f func(item *MenuItem) []*MenuItem) error {
+
+	menu_mutex.Lock()
+
+	item := menu_items[id]
+	if item == nil {
+		menu_mutex.Unlock()
+		return fmt.Errorf("no menu item with id '%s'", id)
+	}
+
+	var states []menu_item_state
+	for _, changed := range f(item) {
+		states = append(states, changed.state())
+	}
+
+	menu_mutex.Unlock()
+
+	send_command_and_content("menustate", states)
+	return nil
+}
+
+func set_checked(item *MenuItem, checked bool) []*MenuItem {
+
+	// Returns every item whose state changed. Checking a radio item unchecks the rest of its group.
+	// The caller holds menu_mutex.
+
+	item.Checked = checked
+	changed := []*MenuItem{item}
+
+	if item.Type != MENU_RADIO || !checked || item.parent == nil {
+		return changed
+	}
+
+	for _, other := range radio_group(item) {
+		if other != item && other.Checked {
+			other.Checked = false
+			changed = append(changed, other)
+		}
+	}
+
+	return changed
+}
+
+func radio_group(item *MenuItem) []*MenuItem {
+
+	items := item.parent.Items
+
+	i := 0
+	for items[i] != item {
+		i++
+	}
+
+	start, end := i, i
+	for start > 0 && items[start - 1].Type == MENU_RADIO {
+		start--
+	}
+	for end < len(items) - 1 && items[end + 1].Type == MENU_RADIO {
+		end++
+	}
+
+	return items[start:end + 1]
+}
+
+func (item *MenuItem) state() menu_item_state {
+	return menu_item_state{Id: item.Id, Label: item.Label, Checked: item.Checked, Enabled: item.Enabled}
+}
+
+func menu_clicked(id string, checked bool) {
+
+	// Called by listener() when a command arrives, before it's queued. The frontend has already
+	// toggled checkboxes and radio items on screen; keep our model the same.
+
+	menu_mutex.Lock()
+	defer menu_mutex.Unlock()
+
+	item := menu_items[id]
+	if item == nil {
+		return
+	}
+
+	if item.Type == MENU_CHECKBOX {
+		item.Checked = checked
+	}
+
+	if item.Type == MENU_RADIO {
+		set_checked(item, true)
+	}
+}
+
+func send_menus_if_dirty() {
+
+	menu_mutex.Lock()
+
+	if !menu_dirty {
+		menu_mutex.Unlock()
+		return
+	}
+
+	menu_dirty = false
+
+	b, err := json.Marshal(menu_bar)		// Now, while we hold the lock.
+
+	menu_mutex.Unlock()
+
+	if err == nil {
+		send_command_and_content("menus", json.RawMessage(b))
+	}
+}
+
+// ----------------------------------------------------------
+
+type window_menu_msg struct {
+	Uid				int							`json:"uid"`
+	Menus			json.RawMessage				`json:"menus"`		// null means no menu bar
+}
+
+func SetWindowMenu(w Window, menus ...*Menu) {
+
+	// Gives the window its own menu bar, made of the menus given, or none at all if there are none.
+	// The structure is sent now; call this again after adding items. State changes made with the
+	// SetMenu...() functions are applied automatically.
+
+	b := []byte("null")
+
+	if len(menus) > 0 {
+
+		var err error
+
+		menu_mutex.Lock()
+		b, err = json.Marshal(menus)
+		menu_mutex.Unlock()
+
+		if err != nil {
+			Logf("SetWindowMenu(): %v", err)
+			return
+		}
+	}
+
+	send_command_and_content("windowmenu", window_menu_msg{Uid: w.GetUID(), Menus: json.RawMessage(b)})
+}
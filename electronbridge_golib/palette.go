@@ -0,0 +1,342 @@
+package electronbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// The frontend looks up each single-rune colour key in colours.json, which maps it to a CSS
+// colour. Backends that draw without a browser (the terminal, for example) need the same
+// lookup done in Go, ending in actual RGB values.
+
+const COLOURS_FILE = "colours.json"
+
+var fallback_palette = map[string]string{		// Used if colours.json can't be read. Same as the file shipped with the repo.
+	"b": "DeepSkyBlue",
+	"g": "LightGreen",
+	"G": "GoldenRod",
+	"o": "Orange",
+	"p": "Purple",
+	"r": "Red",
+	"w": "White",
+	"y": "Yellow",
+	"0": "black",
+}
+
+var file_palette map[string]string
+var file_palette_once sync.Once
+
+func get_file_palette() map[string]string {
+
+	file_palette_once.Do(func() {
+		var err error
+		file_palette, err = parse_commented_json(COLOURS_FILE)
+		if err != nil {
+			file_palette = fallback_palette
+		}
+	})
+
+	return file_palette
+}
+
+func parse_commented_json(filename string) (map[string]string, error) {
+
+	// Same as the function of the same name in grid.html: comments are stripped before parsing.
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(raw), "\n")
+
+	for n, line := range lines {
+		i := strings.Index(line, "//")
+		if i >= 0 {
+			lines[n] = line[:i]
+		}
+	}
+
+	var ret map[string]string
+
+	err = json.Unmarshal([]byte(strings.Join(lines, "\n")), &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func palette_rgb(pal map[string]string, key string) color.RGBA {
+
+	// Unknown keys are white, as they are in grid.html.
+
+	c, ok := parse_css_colour(pal[key])
+	if !ok {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	return c
+}
+
+func cell_rgb(pal map[string]string, key string, rgb color.RGBA) color.RGBA {
+
+	// The colour of a cell, whose key may be RGB_KEY (see SetRGB).
+
+	if key == RGB_KEY {
+		return rgb
+	}
+	return palette_rgb(pal, key)
+}
+
+// ----------------------------------------------------------
+// Palettes set from Go replace colours.json, either for every window (SetPalette) or for one
+// grid (GridWindow.SetPalette). Either way they are sent to the frontend and applied at once.
+// Palette maps are never modified once made, only replaced, so they can be shared freely.
+
+type palette_msg struct {
+	Uid				int							`json:"uid"`		// 0 for the global palette
+	Palette			map[string]string			`json:"palette"`
+}
+
+var go_palette map[string]string				// Set by SetPalette(); nil means use colours.json
+var go_palette_mutex sync.Mutex
+
+func active_palette() map[string]string {
+
+	go_palette_mutex.Lock()
+	defer go_palette_mutex.Unlock()
+
+	if go_palette != nil {
+		return go_palette
+	}
+	return get_file_palette()
+}
+
+func SetPalette(pal map[rune]string) error {
+
+	// Grids with their own palette keep it.
+
+	converted, err := convert_palette(pal)
+	if err != nil {
+		return fmt.Errorf("SetPalette(): %v", err)
+	}
+
+	go_palette_mutex.Lock()
+	go_palette = converted
+	go_palette_mutex.Unlock()
+
+	send_command_and_content("palette", palette_msg{Uid: 0, Palette: converted})
+	return nil
+}
+
+func convert_palette(pal map[rune]string) (map[string]string, error) {
+
+	ret := make(map[string]string)
+
+	for key, css := range pal {
+		if string(key) == RGB_KEY {
+			return nil, fmt.Errorf("'%s' is reserved for SetRGB() cells", RGB_KEY)
+		}
+		if _, ok := parse_css_colour(css); !ok {
+			return nil, fmt.Errorf("can't parse colour '%s' for key '%c'", css, key)
+		}
+		ret[string(key)] = css
+	}
+
+	return ret, nil
+}
+
+// ----------------------------------------------------------
+
+func parse_css_colour(s string) (color.RGBA, bool) {
+
+	// Handles named colours, #rgb, #rrggbb and rgb(r, g, b).
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if c, ok := css_colours[s]; ok {
+		return c, true
+	}
+
+	var r, g, b uint8
+
+	if strings.HasPrefix(s, "#") {
+		if len(s) == 7 {
+			_, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b)
+			if err == nil {
+				return color.RGBA{r, g, b, 255}, true
+			}
+		}
+		if len(s) == 4 {
+			_, err := fmt.Sscanf(s, "#%1x%1x%1x", &r, &g, &b)
+			if err == nil {
+				return color.RGBA{r * 17, g * 17, b * 17, 255}, true
+			}
+		}
+		return color.RGBA{}, false
+	}
+
+	if strings.HasPrefix(s, "rgb(") {
+		_, err := fmt.Sscanf(strings.Replace(s, " ", "", -1), "rgb(%d,%d,%d)", &r, &g, &b)
+		if err == nil {
+			return color.RGBA{r, g, b, 255}, true
+		}
+	}
+
+	return color.RGBA{}, false
+}
+
+var css_colours = map[string]color.RGBA{
+	"aliceblue": {240, 248, 255, 255},
+	"antiquewhite": {250, 235, 215, 255},
+	"aqua": {0, 255, 255, 255},
+	"aquamarine": {127, 255, 212, 255},
+	"azure": {240, 255, 255, 255},
+	"beige": {245, 245, 220, 255},
+	"bisque": {255, 228, 196, 255},
+	"black": {0, 0, 0, 255},
+	"blanchedalmond": {255, 235, 205, 255},
+	"blue": {0, 0, 255, 255},
+	"blueviolet": {138, 43, 226, 255},
+	"brown": {165, 42, 42, 255},
+	"burlywood": {222, 184, 135, 255},
+	"cadetblue": {95, 158, 160, 255},
+	"chartreuse": {127, 255, 0, 255},
+	"chocolate": {210, 105, 30, 255},
+	"coral": {255, 127, 80, 255},
+	"cornflowerblue": {100, 149, 237, 255},
+	"cornsilk": {255, 248, 220, 255},
+	"crimson": {220, 20, 60, 255},
+	"cyan": {0, 255, 255, 255},
+	"darkblue": {0, 0, 139, 255},
+	"darkcyan": {0, 139, 139, 255},
+	"darkgoldenrod": {184, 134, 11, 255},
+	"darkgray": {169, 169, 169, 255},
+	"darkgreen": {0, 100, 0, 255},
+	"darkgrey": {169, 169, 169, 255},
+	"darkkhaki": {189, 183, 107, 255},
+	"darkmagenta": {139, 0, 139, 255},
+	"darkolivegreen": {85, 107, 47, 255},
+	"darkorange": {255, 140, 0, 255},
+	"darkorchid": {153, 50, 204, 255},
+	"darkred": {139, 0, 0, 255},
+	"darksalmon": {233, 150, 122, 255},
+	"darkseagreen": {143, 188, 143, 255},
+	"darkslateblue": {72, 61, 139, 255},
+	"darkslategray": {47, 79, 79, 255},
+	"darkslategrey": {47, 79, 79, 255},
+	"darkturquoise": {0, 206, 209, 255},
+	"darkviolet": {148, 0, 211, 255},
+	"deeppink": {255, 20, 147, 255},
+	"deepskyblue": {0, 191, 255, 255},
+	"dimgray": {105, 105, 105, 255},
+	"dimgrey": {105, 105, 105, 255},
+	"dodgerblue": {30, 144, 255, 255},
+	"firebrick": {178, 34, 34, 255},
+	"floralwhite": {255, 250, 240, 255},
+	"forestgreen": {34, 139, 34, 255},
+	"fuchsia": {255, 0, 255, 255},
+	"gainsboro": {220, 220, 220, 255},
+	"ghostwhite": {248, 248, 255, 255},
+	"gold": {255, 215, 0, 255},
+	"goldenrod": {218, 165, 32, 255},
+	"gray": {128, 128, 128, 255},
+	"green": {0, 128, 0, 255},
+	"greenyellow": {173, 255, 47, 255},
+	"grey": {128, 128, 128, 255},
+	"honeydew": {240, 255, 240, 255},
+	"hotpink": {255, 105, 180, 255},
+	"indianred": {205, 92, 92, 255},
+	"indigo": {75, 0, 130, 255},
+	"ivory": {255, 255, 240, 255},
+	"khaki": {240, 230, 140, 255},
+	"lavender": {230, 230, 250, 255},
+	"lavenderblush": {255, 240, 245, 255},
+	"lawngreen": {124, 252, 0, 255},
+	"lemonchiffon": {255, 250, 205, 255},
+	"lightblue": {173, 216, 230, 255},
+	"lightcoral": {240, 128, 128, 255},
+	"lightcyan": {224, 255, 255, 255},
+	"lightgoldenrodyellow": {250, 250, 210, 255},
+	"lightgray": {211, 211, 211, 255},
+	"lightgreen": {144, 238, 144, 255},
+	"lightgrey": {211, 211, 211, 255},
+	"lightpink": {255, 182, 193, 255},
+	"lightsalmon": {255, 160, 122, 255},
+	"lightseagreen": {32, 178, 170, 255},
+	"lightskyblue": {135, 206, 250, 255},
+	"lightslategray": {119, 136, 153, 255},
+	"lightslategrey": {119, 136, 153, 255},
+	"lightsteelblue": {176, 196, 222, 255},
+	"lightyellow": {255, 255, 224, 255},
+	"lime": {0, 255, 0, 255},
+	"limegreen": {50, 205, 50, 255},
+	"linen": {250, 240, 230, 255},
+	"magenta": {255, 0, 255, 255},
+	"maroon": {128, 0, 0, 255},
+	"mediumaquamarine": {102, 205, 170, 255},
+	"mediumblue": {0, 0, 205, 255},
+	"mediumorchid": {186, 85, 211, 255},
+	"mediumpurple": {147, 112, 219, 255},
+	"mediumseagreen": {60, 179, 113, 255},
+	"mediumslateblue": {123, 104, 238, 255},
+	"mediumspringgreen": {0, 250, 154, 255},
+	"mediumturquoise": {72, 209, 204, 255},
+	"mediumvioletred": {199, 21, 133, 255},
+	"midnightblue": {25, 25, 112, 255},
+	"mintcream": {245, 255, 250, 255},
+	"mistyrose": {255, 228, 225, 255},
+	"moccasin": {255, 228, 181, 255},
+	"navajowhite": {255, 222, 173, 255},
+	"navy": {0, 0, 128, 255},
+	"oldlace": {253, 245, 230, 255},
+	"olive": {128, 128, 0, 255},
+	"olivedrab": {107, 142, 35, 255},
+	"orange": {255, 165, 0, 255},
+	"orangered": {255, 69, 0, 255},
+	"orchid": {218, 112, 214, 255},
+	"palegoldenrod": {238, 232, 170, 255},
+	"palegreen": {152, 251, 152, 255},
+	"paleturquoise": {175, 238, 238, 255},
+	"palevioletred": {219, 112, 147, 255},
+	"papayawhip": {255, 239, 213, 255},
+	"peachpuff": {255, 218, 185, 255},
+	"peru": {205, 133, 63, 255},
+	"pink": {255, 192, 203, 255},
+	"plum": {221, 160, 221, 255},
+	"powderblue": {176, 224, 230, 255},
+	"purple": {128, 0, 128, 255},
+	"rebeccapurple": {102, 51, 153, 255},
+	"red": {255, 0, 0, 255},
+	"rosybrown": {188, 143, 143, 255},
+	"royalblue": {65, 105, 225, 255},
+	"saddlebrown": {139, 69, 19, 255},
+	"salmon": {250, 128, 114, 255},
+	"sandybrown": {244, 164, 96, 255},
+	"seagreen": {46, 139, 87, 255},
+	"seashell": {255, 245, 238, 255},
+	"sienna": {160, 82, 45, 255},
+	"silver": {192, 192, 192, 255},
+	"skyblue": {135, 206, 235, 255},
+	"slateblue": {106, 90, 205, 255},
+	"slategray": {112, 128, 144, 255},
+	"slategrey": {112, 128, 144, 255},
+	"snow": {255, 250, 250, 255},
+	"springgreen": {0, 255, 127, 255},
+	"steelblue": {70, 130, 180, 255},
+	"tan": {210, 180, 140, 255},
+	"teal": {0, 128, 128, 255},
+	"thistle": {216, 191, 216, 255},
+	"tomato": {255, 99, 71, 255},
+	"turquoise": {64, 224, 208, 255},
+	"violet": {238, 130, 238, 255},
+	"wheat": {245, 222, 179, 255},
+	"white": {255, 255, 255, 255},
+	"whitesmoke": {245, 245, 245, 255},
+	"yellow": {255, 255, 0, 255},
+	"yellowgreen": {154, 205, 50, 255},
+}
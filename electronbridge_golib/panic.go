@@ -0,0 +1,163 @@
+package electronbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// A panic in the Go app normally just dies on stderr, and the frontend shows whatever lines of
+// the trace happen to make it into the dev log. Instead, panics in the function given to Run(),
+// in goroutines that defer Recover(), and in the library's own goroutines are reported to the
+// frontend as a single "panic" message, optionally written to a crash file, and then the app
+// exits with status 2, as Go itself would.
+
+type panic_report struct {
+	Message			string						`json:"message"`
+	Stack			string						`json:"stack"`
+}
+
+var crash_file string
+var crash_file_mutex sync.Mutex
+
+var panic_once sync.Once
+
+func Run(f func()) {
+
+	// Typical use: func main() { electron.Run(real_main) }
+
+	defer func() {
+		if r := recover(); r != nil {
+			report_panic(r, debug.Stack())
+		}
+	}()
+
+	f()
+}
+
+func Recover() {
+
+	// For goroutines started by the app: defer electron.Recover()
+
+	if r := recover(); r != nil {
+		report_panic(r, debug.Stack())
+	}
+}
+
+func SetCrashFile(filename string, n int) {
+
+	// If filename is not "", a panic also writes a crash file: the message and stack trace,
+	// followed by the last n protocol messages in the same format as StartRecording() uses.
+
+	crash_file_mutex.Lock()
+	crash_file = filename
+	crash_file_mutex.Unlock()
+
+	if filename == "" {
+		n = 0
+	}
+
+	history_size_chan <- n
+}
+
+func go_safely(f func()) {
+
+	// Used for the library's own goroutines.
+
+	go func() {
+		defer Recover()
+		f()
+	}()
+}
+
+// ----------------------------------------------------------
+
+func report_panic(r interface{}, stack []byte) {
+
+	// Only the first panic gets reported; any others (e.g. caused by the first) just wait for the exit.
+
+	first := false
+
+	panic_once.Do(func() {
+		first = true
+	})
+
+	if !first {
+		select {}
+	}
+
+	report := panic_report{
+		Message: fmt.Sprintf("%v", r),
+		Stack: string(stack),
+	}
+
+	trace := []byte(fmt.Sprintf("panic: %s\n\n%s\n", report.Message, report.Stack))
+
+	// The trace is sent once: to the frontend if there's one that shows "panic" messages (main.js
+	// puts it in the dev log), otherwise to stderr. Not via ERR_msg_chan, which copies it to Electron.
+	//
+	// The panic might have been in one of our own goroutines, which could leave the channels
+	// below with nobody listening. So give up after a while and exit anyway.
+
+	done := make(chan bool)
+
+	go func() {
+		frontend := !terminal_active() && !browser_active()
+		StopTerminal()				// Otherwise the report lands on a raw mode alternate screen.
+		if frontend {
+			send_command_and_content("panic", report)
+		}
+		write_crash_file(report)
+		flush_output()
+		if !frontend {
+			os.Stderr.Write(trace)
+		}
+		done <- true
+	}()
+
+	select {
+	case <- done:
+	case <- time.After(2 * time.Second):
+		os.Stderr.Write(trace)
+	}
+
+	os.Exit(2)
+}
+
+func write_crash_file(report panic_report) {
+
+	crash_file_mutex.Lock()
+	filename := crash_file
+	crash_file_mutex.Unlock()
+
+	if filename == "" {
+		return
+	}
+
+	response_chan := make(chan []record_entry)
+	history_query_chan <- response_chan
+	history := <- response_chan
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "panic: %s\n\n%s\n", report.Message, report.Stack)
+	fmt.Fprintf(&buf, "--- last %d messages (t = ms since start) ---\n", len(history))
+
+	for _, entry := range history {
+		b, err := json.Marshal(entry)
+		if err == nil {
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+	}
+
+	err := ioutil.WriteFile(filename, buf.Bytes(), 0644)
+	if err != nil {
+		ERR_msg_chan <- []byte(fmt.Sprintf("write_crash_file: %v\n", err))
+	}
+}
@@ -0,0 +1,357 @@
+package electronbridge
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Draws grid contents into images in pure Go, using the 5x7 font in font.go and the
+// colours from colours.json, so no frontend is needed. It won't look quite like Electron's
+// rendering, but the same cells have the same characters and colours.
+//
+// At scale 1 each cell is RASTER_CELL_WIDTH x RASTER_CELL_HEIGHT pixels; the glyph sits inside
+// with a 1 pixel margin. Characters the font doesn't have are drawn as a hollow box, except for
+// a few block elements which are common in grid games. Tiles are scaled to fit the cell.
+
+const (
+	RASTER_CELL_WIDTH = 7
+	RASTER_CELL_HEIGHT = 9
+)
+
+type grid_snapshot struct {
+	Width			int
+	Height			int
+	Chars			[]string
+	Colours			[]string
+	Backgrounds		[]string
+	ColourRGBs		[]color.RGBA
+	BackgroundRGBs	[]color.RGBA
+	Attrs			[]uint8
+	Tiles			[]int
+	Tints			[]color.RGBA
+	Palette			map[string]string
+	Time			time.Time
+}
+
+func (b *CellBuffer) snapshot() *grid_snapshot {
+
+	// The caller holds b.Mutex.
+
+	s := &grid_snapshot{Width: b.Width, Height: b.Height, Palette: b.palette(), Time: time.Now()}
+
+	s.Chars = append([]string(nil), b.Chars...)
+	s.Colours = append([]string(nil), b.Colours...)
+	s.Backgrounds = append([]string(nil), b.Backgrounds...)
+	s.ColourRGBs = append([]color.RGBA(nil), b.ColourRGBs...)
+	s.BackgroundRGBs = append([]color.RGBA(nil), b.BackgroundRGBs...)
+	s.Attrs = append([]uint8(nil), b.Attrs...)
+	s.Tiles = append([]int(nil), b.Tiles...)
+	s.Tints = append([]color.RGBA(nil), b.Tints...)
+
+	return s
+}
+
+func (s *grid_snapshot) colour(x, y int) color.RGBA {
+	index := y * s.Width + x
+	return cell_rgb(s.Palette, s.Colours[index], s.ColourRGBs[index])
+}
+
+func (s *grid_snapshot) background(x, y int) color.RGBA {
+	index := y * s.Width + x
+	return cell_rgb(s.Palette, s.Backgrounds[index], s.BackgroundRGBs[index])
+}
+
+func (b *CellBuffer) Image(scale int) *image.RGBA {
+
+	b.Mutex.Lock()
+	s := b.snapshot()
+	b.Mutex.Unlock()
+
+	return s.render(scale)
+}
+
+func (b *CellBuffer) SavePNG(filename string, scale int) error {
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = png.Encode(outfile, b.Image(scale))
+	if err != nil {
+		outfile.Close()
+		return err
+	}
+
+	return outfile.Close()
+}
+
+// ----------------------------------------------------------
+
+func (s *grid_snapshot) render(scale int) *image.RGBA {
+
+	if scale < 1 {
+		scale = 1
+	}
+
+	cw := RASTER_CELL_WIDTH * scale
+	ch := RASTER_CELL_HEIGHT * scale
+
+	img := image.NewRGBA(image.Rect(0, 0, s.Width * cw, s.Height * ch))
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+
+			index := y * s.Width + x
+
+			attrs := s.Attrs[index]
+			fg, bg := apply_colour_attrs(s.colour(x, y), s.background(x, y), attrs)
+
+			char, _ := utf8.DecodeRuneInString(s.Chars[index])
+			if s.Chars[index] == WIDE_CONTINUATION {
+				char = ' '					// The wide character's own cell has the (hollow box) glyph.
+			}
+
+			if s.Tiles[index] != NO_TILE {
+				char = ' '					// The tile is drawn instead.
+			}
+
+			for j := 0; j < ch; j++ {
+				for i := 0; i < cw; i++ {
+					c := bg
+					if attr_glyph_pixel(char, attrs, i / scale, j / scale) {
+						c = fg
+					}
+					img.SetRGBA(x * cw + i, y * ch + j, c)
+				}
+			}
+
+			if s.Tiles[index] != NO_TILE {
+				draw_tile(img, image.Rect(x * cw, y * ch, (x + 1) * cw, (y + 1) * ch), s.Tiles[index], s.Tints[index])
+			}
+		}
+	}
+
+	return img
+}
+
+func attr_glyph_pixel(char rune, attrs uint8, gx, gy int) bool {
+
+	// As glyph_pixel(), with the attributes that change the shape. Blink is drawn as if on.
+
+	if attrs & ATTR_UNDERLINE != 0 && gy == RASTER_CELL_HEIGHT - 1 {
+		return true
+	}
+
+	if attrs & ATTR_STRIKETHROUGH != 0 && gy == RASTER_CELL_HEIGHT / 2 {
+		return true
+	}
+
+	if attrs & ATTR_ITALIC != 0 {
+		gx -= (RASTER_CELL_HEIGHT - 2 - gy) / 3		// Shear: the top rows move right.
+	}
+
+	if attrs & ATTR_BOLD != 0 && gx > 0 && glyph_pixel(char, gx - 1, gy) {
+		return true
+	}
+
+	return glyph_pixel(char, gx, gy)
+}
+
+func glyph_pixel(char rune, gx, gy int) bool {
+
+	// Whether the pixel at gx, gy (in scale 1 cell coordinates) is foreground.
+
+	switch char {
+	case '█':
+		return true
+	case '▀':
+		return gy * 2 < RASTER_CELL_HEIGHT
+	case '▄':
+		return gy * 2 >= RASTER_CELL_HEIGHT
+	case '▌':
+		return gx * 2 < RASTER_CELL_WIDTH
+	case '▐':
+		return gx * 2 >= RASTER_CELL_WIDTH
+	case '░':
+		return gx % 2 == 0 && gy % 2 == 0
+	case '▒':
+		return (gx + gy) % 2 == 0
+	case '▓':
+		return gx % 2 == 1 || gy % 2 == 1
+	}
+
+	// Everything else is drawn in the glyph area, which has a margin of 1...
+
+	fx, fy := gx - 1, gy - 1
+
+	if fx < 0 || fx >= 5 || fy < 0 || fy >= 7 {
+		return false
+	}
+
+	if char == '·' {
+		return fx == 2 && fy == 3
+	}
+
+	if char < 32 || char > 126 {
+		return fx == 0 || fx == 4 || fy == 0 || fy == 6		// Hollow box
+	}
+
+	return font_5x7[char - 32][fx] & (1 << uint(fy)) != 0
+}
+
+// ----------------------------------------------------------
+
+type FlipRecorder struct {
+	mutex			sync.Mutex
+	window			*GridWindow
+	frames			[]*grid_snapshot
+}
+
+func (w *GridWindow) RecordFlips() *FlipRecorder {
+
+	// Starts keeping a copy of every frame actually sent to the frontend, until Stop().
+	// Frames dropped by the backend (see BackendCanDrop) are not kept, since nobody saw them.
+
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+
+	r := &FlipRecorder{window: w}
+	w.FlipRecorders = append(w.FlipRecorders, r)
+
+	return r
+}
+
+func (w *GridWindow) record_flip(frame *GridWindow) {
+
+	// frame is what's being sent: w itself, or the result of compositing its layers.
+	// The caller holds w.Mutex.
+
+	if len(w.FlipRecorders) == 0 {
+		return
+	}
+
+	s := frame.snapshot()
+
+	for _, r := range w.FlipRecorders {
+		r.mutex.Lock()
+		r.frames = append(r.frames, s)
+		r.mutex.Unlock()
+	}
+}
+
+func (r *FlipRecorder) Stop() {
+
+	w := r.window
+
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+
+	for n, other := range w.FlipRecorders {
+		if other == r {
+			w.FlipRecorders = append(w.FlipRecorders[:n], w.FlipRecorders[n + 1:]...)
+			break
+		}
+	}
+}
+
+func (r *FlipRecorder) Len() int {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.frames)
+}
+
+func (r *FlipRecorder) WriteGIF(out io.Writer, scale int) error {
+
+	// Each frame is shown for as long as it really was on screen (to GIF's 1/100 s resolution).
+
+	r.mutex.Lock()
+	frames := append([]*grid_snapshot(nil), r.frames...)
+	r.mutex.Unlock()
+
+	if len(frames) == 0 {
+		return errors.New("FlipRecorder.WriteGIF(): no frames")
+	}
+
+	var images []*image.RGBA
+	for _, s := range frames {
+		images = append(images, s.render(scale))
+	}
+
+	pal := gif_palette(images)
+
+	anim := &gif.GIF{}
+
+	for n, img := range images {
+
+		paletted := image.NewPaletted(img.Bounds(), pal)
+		draw.Draw(paletted, img.Bounds(), img, image.ZP, draw.Src)
+
+		delay := 100		// The last frame has nothing to measure against.
+		if n < len(frames) - 1 {
+			delay = int(frames[n + 1].Time.Sub(frames[n].Time) / (10 * time.Millisecond))
+		}
+		if delay < 2 {
+			delay = 2		// Many viewers treat less than this as some default.
+		}
+
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	return gif.EncodeAll(out, anim)
+}
+
+func (r *FlipRecorder) SaveGIF(filename string, scale int) error {
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = r.WriteGIF(outfile, scale)
+	if err != nil {
+		outfile.Close()
+		return err
+	}
+
+	return outfile.Close()
+}
+
+func gif_palette(images []*image.RGBA) color.Palette {
+
+	// The exact colours used, if there are few enough (there usually are), else a general palette.
+
+	seen := make(map[color.RGBA]bool)
+	var pal color.Palette
+
+	for _, img := range images {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := img.RGBAAt(x, y)
+				if !seen[c] {
+					if len(pal) == 256 {
+						return palette.Plan9
+					}
+					seen[c] = true
+					pal = append(pal, c)
+				}
+			}
+		}
+	}
+
+	return pal
+}
@@ -0,0 +1,238 @@
+package electronbridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A recording is a JSONL file, one record_entry per line. Incoming and outgoing protocol
+// messages are stored verbatim; log lines (i.e. stderr) are stored as JSON strings.
+
+const (
+	RECORD_IN = "in"
+	RECORD_OUT = "out"
+	RECORD_LOG = "log"
+)
+
+type record_entry struct {
+	T				float64						`json:"t"`			// Milliseconds since recording started
+	Dir				string						`json:"dir"`
+	Msg				json.RawMessage				`json:"msg"`
+}
+
+type record_start struct {
+	response_chan	chan error
+	filename		string
+}
+
+var record_chan = make(chan record_entry)
+var record_start_chan = make(chan record_start)
+var record_stop_chan = make(chan chan error)
+
+var history_size_chan = make(chan int)
+var history_query_chan = make(chan chan []record_entry)
+
+var recorder_wanted int32			// Non-zero if recording or keeping history; otherwise record() does nothing.
+
+var replay_active bool
+var replay_mutex sync.Mutex
+
+// ----------------------------------------------------------
+
+func recorder_hub() {
+
+	var file *os.File
+	var started time.Time
+
+	// Independently of any recording, we can keep the last few messages for crash reports (see panic.go).
+	// Their timestamps are relative to the start of the program.
+
+	var history []record_entry
+	var history_size int
+	hub_started := time.Now()
+
+	for {
+
+		if file != nil || history_size > 0 {
+			atomic.StoreInt32(&recorder_wanted, 1)
+		} else {
+			atomic.StoreInt32(&recorder_wanted, 0)
+		}
+
+		select {
+
+		case entry := <- record_chan:
+
+			if history_size > 0 {
+				h := entry
+				h.T = float64(time.Since(hub_started)) / float64(time.Millisecond)
+				history = append(history, h)
+				if len(history) > history_size {
+					history = history[len(history) - history_size:]
+				}
+			}
+
+			if file == nil {
+				continue
+			}
+
+			entry.T = float64(time.Since(started)) / float64(time.Millisecond)
+
+			b, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+
+			b = append(b, '\n')
+
+			_, err = file.Write(b)
+			if err != nil {
+				file.Close()
+				file = nil
+				ERR_msg_chan <- []byte(fmt.Sprintf("recorder_hub: %v (recording stopped)\n", err))		// Not Logf(), which would come back here.
+			}
+
+		case query := <- record_start_chan:
+
+			if file != nil {
+				file.Close()
+			}
+
+			var err error
+			file, err = os.Create(query.filename)
+			if err != nil {
+				file = nil
+			}
+			started = time.Now()
+			query.response_chan <- err
+
+		case response_chan := <- record_stop_chan:
+
+			var err error
+			if file != nil {
+				err = file.Close()
+				file = nil
+			}
+			response_chan <- err
+
+		case history_size = <- history_size_chan:
+
+			if len(history) > history_size {
+				history = history[len(history) - history_size:]
+			}
+
+		case response_chan := <- history_query_chan:
+
+			response_chan <- append([]record_entry(nil), history...)
+		}
+	}
+}
+
+func record(dir string, msg []byte) {
+
+	// Called for every message, so when nobody wants them, don't even bother the hub.
+
+	if atomic.LoadInt32(&recorder_wanted) == 0 {
+		return
+	}
+	record_chan <- record_entry{Dir: dir, Msg: json.RawMessage(msg)}
+}
+
+func StartRecording(filename string) error {
+
+	// Every message to and from the frontend, plus every Logf() line, is written to the file
+	// with a timestamp. Starting a new recording ends any current one.
+
+	response_chan := make(chan error)
+	record_start_chan <- record_start{response_chan: response_chan, filename: filename}
+	return <- response_chan
+}
+
+func StopRecording() error {
+	response_chan := make(chan error)
+	record_stop_chan <- response_chan
+	return <- response_chan
+}
+
+// ----------------------------------------------------------
+
+func replaying() bool {
+	replay_mutex.Lock()
+	defer replay_mutex.Unlock()
+	return replay_active
+}
+
+func Replay(filename string, realtime bool) error {
+
+	// Feed the incoming messages of a recording to listener() in place of stdin; anything
+	// arriving on stdin until the replay ends is ignored. If realtime is true, the original timing is
+	// preserved, otherwise messages are sent as fast as the app will take them.
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var entries []record_entry
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64 * 1024 * 1024)		// Outgoing grid updates can be long lines.
+
+	for line := 1; scanner.Scan(); line++ {
+
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var entry record_entry
+
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return fmt.Errorf("Replay(): %s line %d: %v", filename, line, err)
+		}
+
+		if entry.Dir == RECORD_IN {
+			entries = append(entries, entry)
+		}
+	}
+
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+
+	replay_mutex.Lock()
+	replay_active = true
+	replay_mutex.Unlock()
+
+	go_safely(func() {
+		replayer(entries, realtime)
+	})
+
+	return nil
+}
+
+func replayer(entries []record_entry, realtime bool) {
+
+	started := time.Now()
+
+	for _, entry := range entries {
+		if realtime {
+			due := started.Add(time.Duration(entry.T * float64(time.Millisecond)))
+			time.Sleep(time.Until(due))
+		}
+		incoming_line_chan <- []byte(entry.Msg)
+	}
+
+	replay_mutex.Lock()
+	replay_active = false			// stdin is listened to again.
+	replay_mutex.Unlock()
+
+	Silentf("Replay finished (%d messages).", len(entries))
+}
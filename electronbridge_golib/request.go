@@ -0,0 +1,79 @@
+package electronbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Some commands need an answer from the frontend (which button the user pressed, and so on).
+// They work like acks: each request carries a unique id, and when listener() gets a "response"
+// message with that id, the result is sent down the channel registered for it.
+
+var pending_requests = make(map[string]chan json.RawMessage)
+var pending_requests_mutex sync.Mutex
+
+var request_maker ack_object
+
+func new_request() (string, chan json.RawMessage) {
+
+	id := request_maker.next()
+	ch := make(chan json.RawMessage, 1)		// Buffered, so listener() never waits on us.
+
+	pending_requests_mutex.Lock()
+	pending_requests[id] = ch
+	pending_requests_mutex.Unlock()
+
+	return id, ch
+}
+
+func handle_response(id string, result json.RawMessage) {
+
+	pending_requests_mutex.Lock()
+	ch := pending_requests[id]
+	delete(pending_requests, id)
+	pending_requests_mutex.Unlock()
+
+	if ch != nil {
+		ch <- result
+	}								// Else the requester gave up waiting; nothing to do.
+}
+
+func await_response(ctx context.Context, id string, ch chan json.RawMessage, result interface{}) error {
+
+	select {
+
+	case raw := <- ch:
+
+		return json.Unmarshal(raw, result)
+
+	case <- ctx.Done():
+
+		pending_requests_mutex.Lock()
+		delete(pending_requests, id)
+		pending_requests_mutex.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+func request(ctx context.Context, command string, args interface{}, result interface{}) error {
+
+	// Sends {"id": ..., "args": args} and waits for the frontend's answer, which is unmarshalled into result.
+
+	type request_msg struct {
+		Id			string						`json:"id"`
+		Args		interface{}					`json:"args"`
+	}
+
+	if terminal_active() || browser_active() {
+		return fmt.Errorf("%s: not available without Electron", command)
+	}
+
+	id, ch := new_request()
+
+	send_command_and_content(command, request_msg{Id: id, Args: args})
+
+	return await_response(ctx, id, ch, result)
+}
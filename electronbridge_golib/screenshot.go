@@ -0,0 +1,44 @@
+package electronbridge
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+)
+
+// Screenshots taken by the frontend, of exactly what is on screen, returned to Go as PNG.
+
+type capture_args struct {
+	Uid				int							`json:"uid"`
+}
+
+type capture_result struct {
+	Png				[]byte						`json:"png"`			// Sent as base64, which encoding/json decodes for us
+	Error			string						`json:"error"`
+}
+
+func Screenshot(ctx context.Context, w Window) ([]byte, error) {
+
+	var result capture_result
+
+	err := request(ctx, "capture", capture_args{Uid: w.GetUID()}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Error != "" {
+		return nil, fmt.Errorf("Screenshot(): %s", result.Error)
+	}
+
+	return result.Png, nil
+}
+
+func ScreenshotToFile(ctx context.Context, w Window, filename string) error {
+
+	b, err := Screenshot(ctx, w)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, b, 0644)
+}
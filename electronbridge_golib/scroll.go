@@ -0,0 +1,442 @@
+package electronbridge
+
+import (
+	"image/color"
+	"strings"
+	"sync"
+)
+
+// Scrolling part of a grid. On a GridWindow, scrolls are remembered until the next flip, and if
+// the frame sent before can be turned into the new one by those scrolls plus a few changed cells,
+// only that much is sent, rather than the whole grid. grid.html rebuilds the frame from it.
+//
+// The frontend must have every frame for this to work, so it's not done in terminal or browser
+// mode, and only once a window has scrolled at all (a copy of the last frame has to be kept).
+
+type Rect struct {
+	X					int							`json:"x"`
+	Y					int							`json:"y"`
+	Width				int							`json:"width"`
+	Height				int							`json:"height"`
+}
+
+func (r Rect) clip(width, height int) Rect {
+
+	// The part of r inside a buffer of the given size (possibly empty).
+
+	x1, y1, x2, y2 := r.X, r.Y, r.X + r.Width, r.Y + r.Height
+
+	if x1 < 0 { x1 = 0 }
+	if y1 < 0 { y1 = 0 }
+	if x2 > width { x2 = width }
+	if y2 > height { y2 = height }
+
+	if x2 < x1 { x2 = x1 }
+	if y2 < y1 { y2 = y1 }
+
+	return Rect{x1, y1, x2 - x1, y2 - y1}
+}
+
+func (r Rect) contains(x, y int) bool {
+	return x >= r.X && x < r.X + r.Width && y >= r.Y && y < r.Y + r.Height
+}
+
+func (b *CellBuffer) Scroll(r Rect, dx, dy int) {
+
+	// Moves the cells inside r by dx, dy. Cells moved out of r are lost; cells left behind are cleared.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	b.scroll(r.clip(b.Width, b.Height), dx, dy)
+}
+
+func (b *CellBuffer) scroll(r Rect, dx, dy int) {
+
+	// r is already clipped. The caller holds b.Mutex. Wide characters split by the move are repaired.
+
+	b.break_wide_across(r)
+	b.scroll_cells(r, dx, dy)
+	b.repair_wide(r)
+}
+
+func (b *CellBuffer) scroll_cells(r Rect, dx, dy int) {
+
+	// Just the move, as the frontend does it. Cells are visited in the order that means
+	// every source is read before it's overwritten. The caller holds b.Mutex.
+
+	for jj := 0; jj < r.Height; jj++ {
+
+		j := r.Y + jj
+		if dy > 0 {
+			j = r.Y + r.Height - 1 - jj
+		}
+
+		for ii := 0; ii < r.Width; ii++ {
+
+			i := r.X + ii
+			if dx > 0 {
+				i = r.X + r.Width - 1 - ii
+			}
+
+			index := j * b.Width + i
+
+			if r.contains(i - dx, j - dy) {
+				src := (j - dy) * b.Width + (i - dx)
+				b.Chars[index] = b.Chars[src]
+				b.Colours[index] = b.Colours[src]
+				b.Backgrounds[index] = b.Backgrounds[src]
+				b.ColourRGBs[index] = b.ColourRGBs[src]
+				b.BackgroundRGBs[index] = b.BackgroundRGBs[src]
+				b.Attrs[index] = b.Attrs[src]
+				b.Tiles[index] = b.Tiles[src]
+				b.Tints[index] = b.Tints[src]
+			} else {
+				b.Chars[index] = CLEAR_CHAR
+				b.Colours[index] = CLEAR_COLOUR
+				b.Backgrounds[index] = CLEAR_BACKGROUND
+				b.ColourRGBs[index] = color.RGBA{}
+				b.BackgroundRGBs[index] = color.RGBA{}
+				b.Attrs[index] = 0
+				b.Tiles[index] = NO_TILE
+				b.Tints[index] = color.RGBA{}
+			}
+		}
+	}
+}
+
+func (w *GridWindow) Scroll(r Rect, dx, dy int) {
+
+	// As CellBuffer.Scroll(), but also remembered so the next flip can be sent compactly.
+
+	w.Mutex.Lock()
+	defer w.Mutex.Unlock()
+
+	r = r.clip(w.Width, w.Height)
+	if r.Width == 0 || r.Height == 0 || (dx == 0 && dy == 0) {
+		return
+	}
+
+	w.scroll(r, dx, dy)
+	w.PendingScrolls = append(w.PendingScrolls, scroll_op{Rect: r, DX: dx, DY: dy})
+}
+
+// ----------------------------------------------------------
+
+type scroll_op struct {
+	Rect
+	DX					int							`json:"dx"`
+	DY					int							`json:"dy"`
+}
+
+type cell_change struct {
+	Index				int							`json:"i"`
+	Char				string						`json:"char"`
+	Colour				string						`json:"colour"`
+	Background			string						`json:"background"`
+	ColourRGB			string						`json:"colourrgb,omitempty"`
+	BackgroundRGB		string						`json:"backgroundrgb,omitempty"`
+	Attrs				uint8						`json:"attrs,omitempty"`
+	Tile				int							`json:"tile,omitempty"`
+	Tint				string						`json:"tint,omitempty"`
+}
+
+type scroll_msg struct {
+	Uid					int							`json:"uid"`
+	CameraX				int							`json:"camerax"`
+	CameraY				int							`json:"cameray"`
+	Title				string						`json:"title"`
+	AckRequired			string						`json:"ackrequired"`
+	Scrolls				[]scroll_op					`json:"scrolls"`
+	Changes				[]cell_change				`json:"changes"`
+}
+
+func (s *grid_snapshot) buffer() *CellBuffer {
+
+	// A CellBuffer sharing the snapshot's slices, so the CellBuffer methods can work on it.
+
+	return &CellBuffer{
+		Width: s.Width,
+		Height: s.Height,
+		Chars: s.Chars,
+		Colours: s.Colours,
+		Backgrounds: s.Backgrounds,
+		ColourRGBs: s.ColourRGBs,
+		BackgroundRGBs: s.BackgroundRGBs,
+		Attrs: s.Attrs,
+		Tiles: s.Tiles,
+		Tints: s.Tints,
+	}
+}
+
+func (w *GridWindow) scroll_message(frame *GridWindow, s *grid_snapshot) *scroll_msg {
+
+	// Returns what to send instead of frame, or nil if the whole frame should be sent.
+	// s is a snapshot of frame. The caller holds w.Mutex.
+
+	if w.SentFrame == nil || len(w.PendingScrolls) == 0 || terminal_active() || browser_active() {
+		return nil
+	}
+
+	if w.SentFrame.Width != s.Width || w.SentFrame.Height != s.Height {
+		return nil
+	}
+
+	// What the frontend will have after the scrolls (which it does without repairing wide characters)...
+
+	before := w.SentFrame.buffer()
+	for _, op := range w.PendingScrolls {
+		before.scroll_cells(op.Rect, op.DX, op.DY)
+	}
+
+	msg := &scroll_msg{
+		Uid: frame.Uid,
+		CameraX: frame.CameraX,
+		CameraY: frame.CameraY,
+		Title: frame.Title,
+		AckRequired: frame.AckRequired,
+		Scrolls: w.PendingScrolls,
+	}
+
+	// ...and what's different from that. If it's a lot, the whole frame is better.
+
+	for n := 0; n < len(s.Chars); n++ {
+
+		if before.Chars[n] == s.Chars[n] && before.Colours[n] == s.Colours[n] && before.Backgrounds[n] == s.Backgrounds[n] &&
+			before.ColourRGBs[n] == s.ColourRGBs[n] && before.BackgroundRGBs[n] == s.BackgroundRGBs[n] && before.Attrs[n] == s.Attrs[n] &&
+			before.Tiles[n] == s.Tiles[n] && before.Tints[n] == s.Tints[n] {
+			continue
+		}
+
+		if len(msg.Changes) >= len(s.Chars) / 4 {
+			return nil
+		}
+
+		change := cell_change{Index: n, Char: s.Chars[n], Colour: s.Colours[n], Background: s.Backgrounds[n], Attrs: s.Attrs[n], Tile: s.Tiles[n]}
+
+		if s.ColourRGBs[n].A != 0 {
+			change.ColourRGB = css_hex(s.ColourRGBs[n])
+		}
+		if s.BackgroundRGBs[n].A != 0 {
+			change.BackgroundRGB = css_hex(s.BackgroundRGBs[n])
+		}
+		if s.Tints[n].A != 0 {
+			change.Tint = css_hex(s.Tints[n])
+		}
+
+		msg.Changes = append(msg.Changes, change)
+	}
+
+	return msg
+}
+
+// ----------------------------------------------------------
+// A ScrollRegion is a rectangle of a GridWindow showing some window onto a list of lines, which
+// can be longer than the rectangle is tall; e.g. a message log. Adding a line while showing the
+// end scrolls the rest up. Nothing is sent until the window is flipped, as usual.
+
+type ScrollRegion struct {
+	mutex			sync.Mutex
+	window			*GridWindow
+	rect			Rect
+	lines			[]*CellBuffer
+	top				int				// Index of the first line shown
+	max_lines		int				// 0 means no limit
+}
+
+func (w *GridWindow) NewScrollRegion(r Rect) *ScrollRegion {
+
+	w.Mutex.Lock()
+	r = r.clip(w.Width, w.Height)
+	w.Mutex.Unlock()
+
+	return &ScrollRegion{window: w, rect: r}
+}
+
+func (sr *ScrollRegion) SetMaxLines(n int) {
+
+	// The oldest lines are forgotten once there are more than n. 0 means no limit.
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.max_lines = n
+	removed := sr.trim()
+	sr.scroll_to(sr.top - removed, removed)
+}
+
+func (sr *ScrollRegion) AddLine(s, colour, background string) {
+
+	// Adds one line per newline-separated part of s. Anything past the width is cut off.
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	check_colours("AddLine", colour, background)
+
+	sr.window.Mutex.Lock()
+	pal := sr.window.Palette
+	sr.window.Mutex.Unlock()
+
+	for _, part := range strings.Split(s, "\n") {
+		line := NewCellBuffer(sr.rect.Width, 1)
+		line.Palette = pal
+		line.Print(0, 0, part, colour, background)
+		sr.add(line)
+	}
+}
+
+func (sr *ScrollRegion) AddBuffer(line *CellBuffer) {
+
+	// Adds the top row of line, for when one colour won't do. Cells past the width are cut off.
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	copied := NewCellBuffer(sr.rect.Width, 1)
+	copied.Blit(line, 0, 0)
+	sr.add(copied)
+}
+
+func (sr *ScrollRegion) add(line *CellBuffer) {
+
+	// The caller holds sr.mutex.
+
+	at_end := sr.top == sr.last_top()
+
+	sr.lines = append(sr.lines, line)
+	removed := sr.trim()
+
+	if at_end {
+		sr.scroll_to(sr.last_top(), removed)
+	} else {
+		sr.scroll_to(sr.top - removed, removed)
+	}
+}
+
+func (sr *ScrollRegion) trim() int {
+
+	// Forgets lines over the limit, returning how many. The caller holds sr.mutex, and deals with sr.top.
+
+	if sr.max_lines <= 0 || len(sr.lines) <= sr.max_lines {
+		return 0
+	}
+
+	removed := len(sr.lines) - sr.max_lines
+	sr.lines = append([]*CellBuffer(nil), sr.lines[removed:]...)
+
+	return removed
+}
+
+func (sr *ScrollRegion) last_top() int {
+	if len(sr.lines) <= sr.rect.Height {
+		return 0
+	}
+	return len(sr.lines) - sr.rect.Height
+}
+
+func (sr *ScrollRegion) ScrollBy(n int) {
+
+	// Positive n shows later lines.
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.scroll_to(sr.top + n, 0)
+}
+
+func (sr *ScrollRegion) ScrollToEnd() {
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.scroll_to(sr.last_top(), 0)
+}
+
+func (sr *ScrollRegion) AtEnd() bool {
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	return sr.top == sr.last_top()
+}
+
+func (sr *ScrollRegion) Len() int {
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	return len(sr.lines)
+}
+
+func (sr *ScrollRegion) Clear() {
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.lines = nil
+	sr.top = 0
+
+	sr.window.ClearRect(sr.rect.X, sr.rect.Y, sr.rect.Width, sr.rect.Height)
+}
+
+func (sr *ScrollRegion) scroll_to(top int, removed int) {
+
+	// Shows lines from top onwards. The lines on screen moved up by removed when they were trimmed,
+	// so the old top is really sr.top - removed. The caller holds sr.mutex.
+
+	if top > sr.last_top() {
+		top = sr.last_top()
+	}
+	if top < 0 {
+		top = 0
+	}
+
+	shift := top - (sr.top - removed)
+	sr.top = top
+
+	w := sr.window
+	r := sr.rect
+
+	if shift == 0 {
+		sr.draw_rows(0, r.Height)			// Cheap, and there may be a new line in view.
+		return
+	}
+
+	if shift >= r.Height || shift <= -r.Height {
+		sr.draw_rows(0, r.Height)
+		return
+	}
+
+	w.Scroll(r, 0, -shift)
+
+	if shift > 0 {
+		sr.draw_rows(r.Height - shift, r.Height)
+	} else {
+		sr.draw_rows(0, -shift)
+	}
+}
+
+func (sr *ScrollRegion) draw_rows(first, last int) {
+
+	// Draws region rows first..last-1 from the lines. The caller holds sr.mutex.
+
+	w := sr.window
+
+	for row := first; row < last; row++ {
+
+		n := sr.top + row
+
+		if n < len(sr.lines) {
+			line := sr.lines[n]
+			line.Mutex.Lock()
+			s := line.snapshot()
+			line.Mutex.Unlock()
+			w.Mutex.Lock()
+			w.blit(s, "", "", sr.rect.X, sr.rect.Y + row)
+			w.Mutex.Unlock()
+		} else {
+			w.ClearRect(sr.rect.X, sr.rect.Y + row, sr.rect.Width, 1)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package electronbridge
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Terminal mode: instead of talking to Electron, GridWindow flips are drawn straight into the
+// terminal using ANSI escape sequences, and the keyboard is read in raw mode. Only one grid
+// is visible at a time (whichever flipped last); TextWindows and most other frontend commands
+// are simply ignored. Logf() still writes to stderr, so redirect it somewhere, e.g. 2>log.txt
+//
+// Raw mode is set with stty, so this works on Unix-likes only. The terminal is put back as it was on
+// StopTerminal(), on SIGINT / SIGTERM, and before a panic is reported.
+
+type terminal_state struct {
+	mutex			sync.Mutex
+	active			bool
+	truecolour		bool
+	stty_saved		string
+	pending			[]byte		// Incomplete escape sequence / UTF-8 from the last read
+	rows			int
+	cols			int
+	size_checked	time.Time
+	signals			chan os.Signal
+}
+
+const TERMINAL_SIZE_INTERVAL = 500 * time.Millisecond
+
+var terminal terminal_state
+
+func terminal_active() bool {
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+	return terminal.active
+}
+
+func StartTerminal() error {
+
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+
+	if terminal.active {
+		return nil
+	}
+
+	saved, err := stty("-g")
+	if err != nil {
+		return fmt.Errorf("StartTerminal(): %v", err)
+	}
+
+	_, err = stty("raw", "-echo")
+	if err != nil {
+		return fmt.Errorf("StartTerminal(): %v", err)
+	}
+
+	colorterm := strings.ToLower(os.Getenv("COLORTERM"))
+
+	terminal.active = true
+	terminal.truecolour = strings.Contains(colorterm, "truecolor") || strings.Contains(colorterm, "24bit")
+	terminal.stty_saved = strings.TrimSpace(saved)
+	terminal.pending = nil
+	terminal.size_checked = time.Time{}
+
+	// Raw mode means Ctrl-C arrives as a key, but a kill from elsewhere still has to leave
+	// the terminal usable.
+
+	terminal.signals = make(chan os.Signal, 1)
+	signal.Notify(terminal.signals, os.Interrupt, syscall.SIGTERM)
+
+	go_safely(func() {
+		terminal_signal_waiter(terminal.signals)
+	})
+
+	OUT_msg_chan <- []byte("\x1b[?1049h\x1b[?25l\x1b[2J")		// Alternate screen, hide cursor, clear.
+
+	return nil
+}
+
+func terminal_signal_waiter(signals chan os.Signal) {
+
+	sig, ok := <- signals
+	if !ok {
+		return						// StopTerminal() was called.
+	}
+
+	StopTerminal()
+
+	if s, ok := sig.(syscall.Signal); ok {
+		os.Exit(128 + int(s))
+	}
+	os.Exit(1)
+}
+
+func StopTerminal() {
+
+	terminal.mutex.Lock()
+	defer terminal.mutex.Unlock()
+
+	if !terminal.active {
+		return
+	}
+
+	terminal.active = false
+
+	signal.Stop(terminal.signals)
+	close(terminal.signals)
+
+	OUT_msg_chan <- []byte("\x1b[0m\x1b[?25h\x1b[?1049l")
+	flush_output()
+
+	stty(terminal.stty_saved)
+}
+
+func terminal_size() (int, int) {
+
+	// Rows and columns, as of the last check, which is redone every so often to notice resizes.
+	// The caller holds terminal.mutex.
+
+	if time.Since(terminal.size_checked) >= TERMINAL_SIZE_INTERVAL {
+
+		terminal.size_checked = time.Now()
+
+		out, err := stty("size")
+		fields := strings.Fields(out)
+
+		if err == nil && len(fields) == 2 {
+			rows, err1 := strconv.Atoi(fields[0])
+			cols, err2 := strconv.Atoi(fields[1])
+			if err1 == nil && err2 == nil && rows > 0 && cols > 0 {
+				terminal.rows, terminal.cols = rows, cols
+			}
+		}
+
+		if terminal.rows == 0 || terminal.cols == 0 {
+			terminal.rows, terminal.cols = 24, 80		// Couldn't tell; assume the traditional size.
+		}
+	}
+
+	return terminal.rows, terminal.cols
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// ----------------------------------------------------------
+
+func terminal_handle(command string, content interface{}) {
+
+	// Called by send_command_and_content() in place of writing to stdout.
+
+	if command != "update" {
+		return
+	}
+
+	w, ok := content.(*GridWindow)
+	if !ok {
+		return
+	}
+
+	terminal_draw(w)				// The caller holds w.Mutex
+
+	if w.AckRequired != "" {
+		handle_ack(w.AckRequired)	// Drawing is synchronous, so the frame is done already.
+	}
+}
+
+func terminal_draw(w *GridWindow) {
+
+	terminal.mutex.Lock()
+	truecolour := terminal.truecolour
+	rows, cols := terminal_size()
+	terminal.mutex.Unlock()
+
+	// Anything past the bottom or right edge of the terminal is left out, since writing it would
+	// scroll or wrap the screen.
+
+	height := w.Height
+	if height > rows {
+		height = rows
+	}
+
+	width := w.Width
+	if width > cols {
+		width = cols
+	}
+
+	pal := w.palette()
+
+	var buf bytes.Buffer
+
+	buf.WriteString("\x1b[H")
+
+	for y := 0; y < height; y++ {
+
+		fmt.Fprintf(&buf, "\x1b[%d;1H", y + 1)
+
+		a, b := y * w.Width, y * w.Width + width
+
+		chars := w.Chars[a:b]
+
+		if width < w.Width && width > 0 && w.Chars[b] == WIDE_CONTINUATION {
+			chars = append([]string(nil), chars...)
+			chars[width - 1] = CLEAR_CHAR		// The wide character would hang over the edge.
+		}
+
+		write_ansi_row(&buf, pal, chars, w.Colours[a:b], w.Backgrounds[a:b], w.ColourRGBs[a:b], w.BackgroundRGBs[a:b], w.Attrs[a:b], truecolour)
+	}
+
+	OUT_msg_chan <- buf.Bytes()
+}
+
+func write_ansi_row(buf *bytes.Buffer, pal map[string]string, chars, colours, backgrounds []string, colour_rgbs, background_rgbs []color.RGBA, attrs []uint8, truecolour bool) {
+
+	// Codes are only written when something changes. Assumes no attributes are set at the start. Ends with a reset.
+
+	var last_fg, last_bg color.RGBA
+	var last_attrs uint8
+
+	for x := 0; x < len(chars); x++ {
+
+		fg := cell_rgb(pal, colours[x], colour_rgbs[x])
+		bg := cell_rgb(pal, backgrounds[x], background_rgbs[x])
+
+		colours_reset := false
+
+		if attrs[x] != last_attrs {
+			last_attrs = attrs[x]
+			buf.WriteString(sgr_attrs(last_attrs))		// This resets the colours too.
+			colours_reset = true
+		}
+
+		if x == 0 || colours_reset || fg != last_fg {
+			last_fg = fg
+			buf.WriteString(sgr_colour(38, fg, truecolour))
+		}
+
+		if x == 0 || colours_reset || bg != last_bg {
+			last_bg = bg
+			buf.WriteString(sgr_colour(48, bg, truecolour))
+		}
+
+		if chars[x] != WIDE_CONTINUATION {		// The terminal moved on 2 columns for the wide character.
+			buf.WriteString(chars[x])
+		}
+	}
+
+	buf.WriteString("\x1b[0m")
+}
+
+func sgr_colour(base int, c color.RGBA, truecolour bool) string {
+
+	// base is 38 for foreground or 48 for background.
+
+	if truecolour {
+		return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", base, c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("\x1b[%d;5;%dm", base, xterm256(c))
+}
+
+func xterm256(c color.RGBA) int {
+
+	// Nearest entry in the 6x6x6 colour cube or the 24-step greyscale ramp, whichever is closer.
+
+	cube := func(v uint8) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (int(v) - 35) / 40
+	}
+
+	levels := []int{0, 95, 135, 175, 215, 255}
+
+	r, g, b := cube(c.R), cube(c.G), cube(c.B)
+	cube_index := 16 + 36 * r + 6 * g + b
+	cube_dist := sq(levels[r] - int(c.R)) + sq(levels[g] - int(c.G)) + sq(levels[b] - int(c.B))
+
+	avg := (int(c.R) + int(c.G) + int(c.B)) / 3
+	grey := (avg - 3) / 10
+	if grey < 0 {
+		grey = 0
+	}
+	if grey > 23 {
+		grey = 23
+	}
+	grey_level := 8 + grey * 10
+	grey_dist := sq(grey_level - int(c.R)) + sq(grey_level - int(c.G)) + sq(grey_level - int(c.B))
+
+	if grey_dist < cube_dist {
+		return 232 + grey
+	}
+	return cube_index
+}
+
+func sq(n int) int {
+	return n * n
+}
+
+// ----------------------------------------------------------
+
+var terminal_escapes = map[string]string{		// Names are those of the JS KeyboardEvent.key, which is what the frontend sends.
+	"\x1b[A": "ArrowUp",
+	"\x1b[B": "ArrowDown",
+	"\x1b[C": "ArrowRight",
+	"\x1b[D": "ArrowLeft",
+	"\x1b[H": "Home",
+	"\x1b[F": "End",
+	"\x1bOA": "ArrowUp",
+	"\x1bOB": "ArrowDown",
+	"\x1bOC": "ArrowRight",
+	"\x1bOD": "ArrowLeft",
+	"\x1bOH": "Home",
+	"\x1bOF": "End",
+	"\x1bOP": "F1",
+	"\x1bOQ": "F2",
+	"\x1bOR": "F3",
+	"\x1bOS": "F4",
+	"\x1b[1~": "Home",
+	"\x1b[2~": "Insert",
+	"\x1b[3~": "Delete",
+	"\x1b[4~": "End",
+	"\x1b[5~": "PageUp",
+	"\x1b[6~": "PageDown",
+	"\x1b[7~": "Home",
+	"\x1b[8~": "End",
+	"\x1b[15~": "F5",
+	"\x1b[17~": "F6",
+	"\x1b[18~": "F7",
+	"\x1b[19~": "F8",
+	"\x1b[20~": "F9",
+	"\x1b[21~": "F10",
+	"\x1b[23~": "F11",
+	"\x1b[24~": "F12",
+}
+
+func terminal_input(b []byte) {
+
+	// Called by stdin_reader() with raw bytes from the terminal. Terminals have no notion of
+	// key release, so each key is sent to key_hub() as a press followed by a release.
+
+	terminal.mutex.Lock()
+	b = append(terminal.pending, b...)
+	terminal.pending = nil
+	terminal.mutex.Unlock()
+
+	for len(b) > 0 {
+
+		key, n := decode_terminal_key(b)
+
+		if n == 0 {							// Incomplete, wait for more.
+			terminal.mutex.Lock()
+			terminal.pending = append([]byte(nil), b...)
+			terminal.mutex.Unlock()
+			return
+		}
+
+		b = b[n:]
+
+		if key == "" {
+			continue
+		}
+
+		if key == "\x03" {					// Ctrl-C doesn't raise a signal in raw mode.
+			quit_chan <- true
+			continue
+		}
+
+		key_down_chan <- key
+		key_up_chan <- key
+	}
+}
+
+func decode_terminal_key(b []byte) (string, int) {
+
+	// Returns the key name and the number of bytes consumed, or 0 bytes if b is incomplete.
+	// An unrecognised sequence or control character consumes its bytes but gives "".
+
+	if b[0] == 0x1b {
+
+		if len(b) == 1 {
+			return "Escape", 1				// We can't tell a lone Escape from a split sequence; assume the former.
+		}
+
+		if b[1] != '[' && b[1] != 'O' {
+			return "Escape", 1
+		}
+
+		for i := 2; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				return terminal_escapes[string(b[:i + 1])], i + 1
+			}
+		}
+
+		return "", 0
+	}
+
+	switch b[0] {
+	case '\r', '\n':
+		return "Enter", 1
+	case '\t':
+		return "Tab", 1
+	case 0x7f, 0x08:
+		return "Backspace", 1
+	case 0x03:
+		return "\x03", 1
+	}
+
+	if b[0] < 0x20 {
+		return "", 1
+	}
+
+	if !utf8.FullRune(b) {
+		return "", 0
+	}
+
+	r, n := utf8.DecodeRune(b)
+	if r == utf8.RuneError {
+		return "", n
+	}
+
+	return string(r), n
+}
@@ -20,6 +20,7 @@ type new_text_win_msg struct {
 	Height			int							`json:"height"`
 	StartHidden		bool						`json:"starthidden"`
 	Resizable		bool						`json:"resizable"`
+	NoMenu			bool						`json:"nomenu"`
 }
 
 type text_update_content struct {
@@ -27,20 +28,42 @@ type text_update_content struct {
 	Msg				string						`json:"msg"`
 }
 
+type TextWindowOptions struct {
+	Name			string
+	Page			string
+	Width			int
+	Height			int
+	StartHidden		bool
+	Resizable		bool
+	NoMenu			bool						// No menu bar at all (see also SetWindowMenu)
+}
+
 func NewTextWindow(name, page string, width, height int, starthidden, resizable bool) *TextWindow {
+	return NewTextWindowWithOptions(TextWindowOptions{
+		Name: name,
+		Page: page,
+		Width: width,
+		Height: height,
+		StartHidden: starthidden,
+		Resizable: resizable,
+	})
+}
+
+func NewTextWindowWithOptions(opts TextWindowOptions) *TextWindow {
 
 	uid := id_maker.next()
 
 	w := TextWindow{Uid: uid}
 
 	c := new_text_win_msg{
-		Name: name,
-		Page: page,
+		Name: opts.Name,
+		Page: opts.Page,
 		Uid: uid,
-		Width: width,
-		Height: height,
-		StartHidden: starthidden,
-		Resizable: resizable,
+		Width: opts.Width,
+		Height: opts.Height,
+		StartHidden: opts.StartHidden,
+		Resizable: opts.Resizable,
+		NoMenu: opts.NoMenu,
 	}
 
 	send_command_and_content("new", c)
@@ -0,0 +1,245 @@
+package electronbridge
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sync"
+)
+
+// Cells can show a tile from a tileset image instead of their character. Tile IDs are global:
+// each registered tileset gets the next block of IDs, numbered left to right, top to bottom,
+// and NO_TILE (0) means the cell shows its character as usual.
+//
+// SetTile() leaves the cell's character and colours alone: the background is drawn behind the
+// tile, and the character is what shows wherever tiles can't be drawn (the terminal, text exports).
+// Set() and friends clear the tile.
+
+const NO_TILE = 0
+
+type Tileset struct {
+	Filename		string
+	TileWidth		int
+	TileHeight		int
+	Columns			int
+	Rows			int
+	First			int				// The ID of the top left tile
+	image			image.Image
+}
+
+type tileset_msg struct {
+	Filename		string						`json:"filename"`		// Absolute, or relative to the app's directory like colours.json
+	TileWidth		int							`json:"tilewidth"`
+	TileHeight		int							`json:"tileheight"`
+	Columns			int							`json:"columns"`
+	Count			int							`json:"count"`
+	First			int							`json:"first"`
+}
+
+var tilesets []*Tileset
+var tilesets_mutex sync.Mutex
+var next_tile_id = 1
+
+func RegisterTileset(filename string, tile_width, tile_height int) (*Tileset, error) {
+
+	// The image is read here too, to check it and so Image() etc. can draw the tiles. Any partial
+	// tiles at the right and bottom edges are ignored.
+
+	if tile_width < 1 || tile_height < 1 {
+		return nil, fmt.Errorf("RegisterTileset(): bad tile size %dx%d", tile_width, tile_height)
+	}
+
+	infile, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("RegisterTileset(): %v", err)
+	}
+	defer infile.Close()
+
+	img, _, err := image.Decode(infile)
+	if err != nil {
+		return nil, fmt.Errorf("RegisterTileset(): %s: %v", filename, err)
+	}
+
+	bounds := img.Bounds()
+
+	ts := &Tileset{
+		Filename: filename,
+		TileWidth: tile_width,
+		TileHeight: tile_height,
+		Columns: bounds.Dx() / tile_width,
+		Rows: bounds.Dy() / tile_height,
+		image: img,
+	}
+
+	if ts.Count() == 0 {
+		return nil, fmt.Errorf("RegisterTileset(): %s is smaller than one tile", filename)
+	}
+
+	tilesets_mutex.Lock()
+	ts.First = next_tile_id
+	next_tile_id += ts.Count()
+	tilesets = append(tilesets, ts)
+	tilesets_mutex.Unlock()
+
+	send_command_and_content("tileset", ts.message())
+	return ts, nil
+}
+
+func (ts *Tileset) Count() int {
+	return ts.Columns * ts.Rows
+}
+
+func (ts *Tileset) Tile(n int) int {
+
+	// The ID of the nth tile in this tileset.
+
+	if n < 0 || n >= ts.Count() {
+		panic(fmt.Sprintf("Tileset.Tile(): %s has no tile %d", ts.Filename, n))
+	}
+	return ts.First + n
+}
+
+func (ts *Tileset) TileAt(column, row int) int {
+
+	if column < 0 || column >= ts.Columns || row < 0 || row >= ts.Rows {
+		panic(fmt.Sprintf("Tileset.TileAt(): %s has no tile at %d, %d", ts.Filename, column, row))
+	}
+	return ts.First + row * ts.Columns + column
+}
+
+func (ts *Tileset) message() tileset_msg {
+	return tileset_msg{
+		Filename: ts.Filename,
+		TileWidth: ts.TileWidth,
+		TileHeight: ts.TileHeight,
+		Columns: ts.Columns,
+		Count: ts.Count(),
+		First: ts.First,
+	}
+}
+
+func tileset_messages() []tileset_msg {
+
+	// For new windows, which need to know about tilesets registered before they were made.
+
+	tilesets_mutex.Lock()
+	defer tilesets_mutex.Unlock()
+
+	var ret []tileset_msg
+	for _, ts := range tilesets {
+		ret = append(ret, ts.message())
+	}
+	return ret
+}
+
+func tileset_for(id int) *Tileset {
+
+	tilesets_mutex.Lock()
+	defer tilesets_mutex.Unlock()
+
+	for _, ts := range tilesets {
+		if id >= ts.First && id < ts.First + ts.Count() {
+			return ts
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------
+
+type tile_slice []int
+
+func (s tile_slice) MarshalJSON() ([]byte, error) {
+
+	// As with rgb_slice: {"index": id} for the cells which have a tile.
+
+	var buf bytes.Buffer
+
+	buf.WriteString("{")
+
+	first := true
+
+	for n, id := range s {
+		if id == NO_TILE {
+			continue
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		fmt.Fprintf(&buf, `"%d":%d`, n, id)
+	}
+
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+func (b *CellBuffer) SetTile(x, y int, id int, tint color.Color) {
+
+	// The tile's colours are multiplied by tint, if it's not nil. NO_TILE removes the tile.
+
+	b.Mutex.Lock()
+	defer b.Mutex.Unlock()
+
+	if id != NO_TILE && tileset_for(id) == nil {
+		panic(fmt.Sprintf("CellBuffer.SetTile(): no tileset has tile %d", id))
+	}
+
+	index := y * b.Width + x
+	if index < 0 || index >= len(b.Chars) || x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+
+	b.Tiles[index] = id
+	b.Tints[index] = color.RGBA{}
+
+	if tint != nil && id != NO_TILE {
+		b.Tints[index] = opaque_rgba(tint)
+	}
+}
+
+// ----------------------------------------------------------
+
+func draw_tile(img *image.RGBA, r image.Rectangle, id int, tint color.RGBA) {
+
+	// Draws the tile scaled (nearest neighbour) into r of img, over what's there already.
+
+	ts := tileset_for(id)
+	if ts == nil {
+		return
+	}
+
+	n := id - ts.First
+	origin := ts.image.Bounds().Min
+	sx := origin.X + (n % ts.Columns) * ts.TileWidth
+	sy := origin.Y + (n / ts.Columns) * ts.TileHeight
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+
+			tx := sx + (x - r.Min.X) * ts.TileWidth / r.Dx()
+			ty := sy + (y - r.Min.Y) * ts.TileHeight / r.Dy()
+
+			c := color.NRGBAModel.Convert(ts.image.At(tx, ty)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+
+			if tint.A != 0 {
+				c.R = uint8(int(c.R) * int(tint.R) / 255)
+				c.G = uint8(int(c.G) * int(tint.G) / 255)
+				c.B = uint8(int(c.B) * int(tint.B) / 255)
+			}
+
+			under := img.RGBAAt(x, y)
+			blend := func(top, bottom uint8) uint8 {
+				return uint8((int(top) * int(c.A) + int(bottom) * (255 - int(c.A))) / 255)
+			}
+
+			img.SetRGBA(x, y, color.RGBA{blend(c.R, under.R), blend(c.G, under.G), blend(c.B, under.B), 255})
+		}
+	}
+}
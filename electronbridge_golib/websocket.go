@@ -0,0 +1,320 @@
+package electronbridge
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of RFC 6455 to talk to a browser: text messages, fragmentation, ping and close.
+// Not extensions, not a client.
+
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT = 0x1
+	WS_BINARY = 0x2
+	WS_CLOSE = 0x8
+	WS_PING = 0x9
+	WS_PONG = 0xa
+
+	WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	WS_MAX_MESSAGE = 16 * 1024 * 1024
+
+	WS_SEND_QUEUE = 64
+	WS_WRITE_TIMEOUT = 10 * time.Second
+
+	WS_STATUS_PROTOCOL_ERROR = 1002
+	WS_STATUS_TOO_BIG = 1009
+)
+
+type ws_fail_error struct {		// A reason to fail the connection, as RFC 6455 calls it
+	status			int
+	reason			string
+}
+
+func (self *ws_fail_error) Error() string {
+	return self.reason
+}
+
+// Messages are written by a goroutine per connection, so a browser that stops reading can't
+// hold anyone else up. If its queue fills, send() refuses further messages.
+
+type ws_conn struct {
+	conn			net.Conn
+	reader			*bufio.Reader
+	write_mutex		sync.Mutex
+	send_chan		chan []byte
+	closed			chan bool
+	close_once		sync.Once
+}
+
+func ws_upgrade(w http.ResponseWriter, r *http.Request, origin_ok func(string) bool) (*ws_conn, error) {
+
+	// origin_ok is given the host (with port) from the Origin header, which browsers always send.
+	// Without this check, any web page could connect to us.
+
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" || !origin_ok(origin.Host) {
+		http.Error(w, "bad origin", http.StatusForbidden)
+		return nil, fmt.Errorf("ws_upgrade(): bad origin %q", r.Header.Get("Origin"))
+	}
+
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "expected websocket", http.StatusBadRequest)
+		return nil, fmt.Errorf("ws_upgrade(): not a websocket request")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("ws_upgrade(): missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack", http.StatusInternalServerError)
+		return nil, fmt.Errorf("ws_upgrade(): ResponseWriter is not a Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + WS_GUID))
+	accept := base64.StdEncoding.EncodeToString(hash[:])
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	self := &ws_conn{
+		conn: conn,
+		reader: rw.Reader,
+		send_chan: make(chan []byte, WS_SEND_QUEUE),
+		closed: make(chan bool),
+	}
+
+	go_safely(self.writer)
+
+	return self, nil
+}
+
+func (self *ws_conn) writer() {
+
+	for {
+		select {
+		case b := <- self.send_chan:
+			err := self.write_message(b)
+			if err != nil {
+				self.close()
+				return
+			}
+		case <- self.closed:
+			return
+		}
+	}
+}
+
+func (self *ws_conn) send(b []byte) bool {
+
+	// Queues b for the writer. Returns false, having done nothing, if the queue is full or the
+	// connection is closed.
+
+	select {
+	case <- self.closed:
+		return false
+	default:
+	}
+
+	select {
+	case self.send_chan <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+func (self *ws_conn) read_frame() (fin bool, opcode byte, payload []byte, err error) {
+
+	var header [2]byte
+
+	_, err = io.ReadFull(self.reader, header[:])
+	if err != nil {
+		return
+	}
+
+	fin = header[0] & 0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1] & 0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	if length == 126 {
+		var ext [2]byte
+		_, err = io.ReadFull(self.reader, ext[:])
+		if err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	} else if length == 127 {
+		var ext [8]byte
+		_, err = io.ReadFull(self.reader, ext[:])
+		if err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		err = &ws_fail_error{WS_STATUS_PROTOCOL_ERROR, "read_frame(): client frame is not masked"}
+		return
+	}
+
+	if length > WS_MAX_MESSAGE {
+		err = &ws_fail_error{WS_STATUS_TOO_BIG, fmt.Sprintf("read_frame(): frame of %d bytes is too large", length)}
+		return
+	}
+
+	var mask [4]byte
+
+	_, err = io.ReadFull(self.reader, mask[:])
+	if err != nil {
+		return
+	}
+
+	payload = make([]byte, length)
+
+	_, err = io.ReadFull(self.reader, payload)
+	if err != nil {
+		return
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i % 4]
+	}
+
+	return
+}
+
+func (self *ws_conn) read_message() ([]byte, error) {
+
+	// Returns the next complete text or binary message, dealing with control frames on the way.
+	// If the client breaks the protocol, the connection is closed with the appropriate status.
+
+	message, err := self.read_message_frames()
+
+	if fail, ok := err.(*ws_fail_error); ok {
+		var payload [2]byte
+		binary.BigEndian.PutUint16(payload[:], uint16(fail.status))
+		self.write_frame(WS_CLOSE, payload[:])
+		self.close()
+	}
+
+	return message, err
+}
+
+func (self *ws_conn) read_message_frames() ([]byte, error) {
+
+	var message []byte
+	in_message := false
+
+	for {
+		fin, opcode, payload, err := self.read_frame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+
+		case WS_PING:
+			self.write_frame(WS_PONG, payload)
+
+		case WS_PONG:
+			// no action
+
+		case WS_CLOSE:
+			self.write_frame(WS_CLOSE, payload)
+			self.close()
+			return nil, io.EOF
+
+		case WS_TEXT, WS_BINARY, WS_CONTINUATION:
+
+			if opcode == WS_CONTINUATION && !in_message {
+				return nil, &ws_fail_error{WS_STATUS_PROTOCOL_ERROR, "read_message(): unexpected continuation frame"}
+			}
+
+			if opcode != WS_CONTINUATION && in_message {
+				return nil, &ws_fail_error{WS_STATUS_PROTOCOL_ERROR, "read_message(): new message before the last one ended"}
+			}
+
+			message = append(message, payload...)
+			in_message = true
+
+			if len(message) > WS_MAX_MESSAGE {
+				return nil, &ws_fail_error{WS_STATUS_TOO_BIG, "read_message(): message too large"}
+			}
+
+			if fin {
+				return message, nil
+			}
+
+		default:
+
+			return nil, &ws_fail_error{WS_STATUS_PROTOCOL_ERROR, fmt.Sprintf("read_message(): unknown opcode %d", opcode)}
+		}
+	}
+}
+
+func (self *ws_conn) write_message(b []byte) error {
+	return self.write_frame(WS_TEXT, b)
+}
+
+func (self *ws_conn) write_frame(opcode byte, payload []byte) error {
+
+	// Server frames are never masked.
+
+	self.write_mutex.Lock()
+	defer self.write_mutex.Unlock()
+
+	header := []byte{0x80 | opcode}
+
+	length := len(payload)
+
+	if length < 126 {
+		header = append(header, byte(length))
+	} else if length <= 0xffff {
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	} else {
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	self.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+
+	_, err := self.conn.Write(append(header, payload...))
+	return err
+}
+
+func (self *ws_conn) close() error {
+	self.close_once.Do(func() {
+		close(self.closed)
+	})
+	return self.conn.Close()
+}
@@ -0,0 +1,98 @@
+package electronbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func ws_test_pair() (*ws_conn, net.Conn) {
+	server, client := net.Pipe()
+	conn := &ws_conn{conn: server, reader: bufio.NewReader(server), send_chan: make(chan []byte, WS_SEND_QUEUE), closed: make(chan bool)}
+	return conn, client
+}
+
+func ws_client_frame(fin bool, opcode byte, payload []byte, masked bool) []byte {
+
+	// Payloads here are short, so the length always fits in the second byte.
+
+	b := []byte{opcode, byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	b[1] |= 0x80
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c ^ mask[i % 4])
+	}
+	return b
+}
+
+func ws_expect_failure(t *testing.T, frames ...[]byte) {
+
+	t.Helper()
+
+	conn, client := ws_test_pair()
+
+	go func() {
+		for _, f := range frames {
+			client.Write(f)
+		}
+	}()
+
+	status := make(chan int)
+
+	go func() {
+		header := make([]byte, 4)
+		_, err := io.ReadFull(client, header)
+		if err != nil || header[0] != 0x80 | WS_CLOSE {
+			status <- 0
+			return
+		}
+		status <- int(binary.BigEndian.Uint16(header[2:]))
+	}()
+
+	msg, err := conn.read_message()
+	if err == nil {
+		t.Errorf("got message %q, want an error", msg)
+	}
+
+	if s := <- status; s != WS_STATUS_PROTOCOL_ERROR {
+		t.Errorf("close status %d, want %d", s, WS_STATUS_PROTOCOL_ERROR)
+	}
+
+	client.Close()
+}
+
+func TestWebsocketFragments(t *testing.T) {
+
+	conn, client := ws_test_pair()
+	defer client.Close()
+
+	go func() {
+		client.Write(ws_client_frame(false, WS_TEXT, []byte("hel"), true))
+		client.Write(ws_client_frame(true, WS_CONTINUATION, []byte("lo"), true))
+	}()
+
+	msg, err := conn.read_message()
+	if err != nil || string(msg) != "hello" {
+		t.Errorf("got %q, %v; want \"hello\"", msg, err)
+	}
+}
+
+func TestWebsocketInterleavedMessage(t *testing.T) {
+	ws_expect_failure(t,
+		ws_client_frame(false, WS_TEXT, []byte("hel"), true),
+		ws_client_frame(true, WS_TEXT, []byte("lo"), true))
+}
+
+func TestWebsocketUnmasked(t *testing.T) {
+	ws_expect_failure(t, ws_client_frame(true, WS_TEXT, []byte("hello"), false))
+}
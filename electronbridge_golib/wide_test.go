@@ -0,0 +1,33 @@
+package electronbridge
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestWideOverLeftEdge(t *testing.T) {
+
+	// Only the continuation would be inside, so a space is drawn there instead.
+
+	b := NewCellBuffer(4, 1)
+	b.Print(-1, 0, "中ab", "w", "0")
+
+	want := []string{" ", "a", "b", " "}
+
+	for x, c := range want {
+		if b.Chars[x] != c {
+			t.Errorf("Print(): cell %d is %q, want %q", x, b.Chars[x], c)
+		}
+	}
+
+	if b.ExportText() != " ab \n" {
+		t.Errorf("ExportText() is %q", b.ExportText())
+	}
+
+	b.Clear()
+	b.SetRGB(-1, 0, "中", color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 0, 255})
+
+	if b.Chars[0] != CLEAR_CHAR || b.Colours[0] != RGB_KEY {
+		t.Errorf("SetRGB(): cell 0 is %q colour %q", b.Chars[0], b.Colours[0])
+	}
+}
@@ -0,0 +1,114 @@
+"use strict";
+
+// Loaded ahead of a window page when the Go backend is in browser mode (see browser.go).
+// The pages are written for Electron, so give them a require() that provides what they use:
+// ipcRenderer (over a WebSocket to the Go process), fs.readFileSync (over synchronous XHR),
+// alert, and relative modules like ./animations.
+
+(function () {
+
+	const uid = parseInt(new URLSearchParams(window.location.search).get("uid"), 10);
+
+	const socket = new WebSocket(`ws://${window.location.host}/ws?uid=${uid}`);
+
+	let handlers = Object.create(null);		// channel --> array of functions
+	let outbox = [];						// Messages sent before the socket opened
+	let module_cache = Object.create(null);
+
+	socket.onopen = () => {
+		for (let n = 0; n < outbox.length; n++) {
+			socket.send(outbox[n]);
+		}
+		outbox = null;
+	};
+
+	socket.onmessage = (evt) => {
+		let j = JSON.parse(evt.data);
+		let list = handlers[j.channel];
+		if (list === undefined) {
+			return;
+		}
+		for (let n = 0; n < list.length; n++) {
+			list[n](null, j.msg);
+		}
+	};
+
+	socket.onclose = () => {
+		document.title = "(disconnected) " + document.title;
+	};
+
+	const ipcRenderer = {
+		send: (channel, msg) => {
+			let s = JSON.stringify({channel: channel, msg: msg === undefined ? null : msg});
+			if (outbox !== null) {
+				outbox.push(s);
+			} else if (socket.readyState === WebSocket.OPEN) {
+				socket.send(s);
+			}
+		},
+		on: (channel, fn) => {
+			if (handlers[channel] === undefined) {
+				handlers[channel] = [];
+			}
+			handlers[channel].push(fn);
+		},
+	};
+
+	function sync_get(path) {
+		let xhr = new XMLHttpRequest();
+		xhr.open("GET", path, false);
+		xhr.send(null);
+		if (xhr.status !== 200) {
+			throw new Error(`browser_shim.js: couldn't get ${path} (${xhr.status})`);
+		}
+		return xhr.responseText;
+	}
+
+	const fs = {
+		readFileSync: (filename) => {
+			let text = sync_get("/" + filename.replace(/\\/g, "/"));		// Relative to the Go process' working directory, like under Electron.
+			return {toString: () => text};
+		},
+	};
+
+	function alert_shim(msg) {
+		if (typeof(msg) === "object") {
+			msg = JSON.stringify(msg);
+		}
+		window.alert(String(msg).trim());
+	}
+
+	function load_module(name) {
+
+		let url = new URL(name.endsWith(".js") ? name : name + ".js", window.location.href).pathname;
+
+		if (module_cache[url] !== undefined) {
+			return module_cache[url].exports;
+		}
+
+		let module = {exports: {}};
+		module_cache[url] = module;
+
+		let f = new Function("exports", "require", "module", sync_get(url) + `\n//# sourceURL=${url}`);
+		f(module.exports, window.require, module);
+
+		return module.exports;
+	}
+
+	window.require = (name) => {
+		if (name === "electron") {
+			return {ipcRenderer: ipcRenderer};
+		}
+		if (name === "fs") {
+			return fs;
+		}
+		if (name.endsWith("electronbridge_js/alert")) {
+			return alert_shim;
+		}
+		if (name.startsWith(".")) {
+			return load_module(name);
+		}
+		throw new Error(`browser_shim.js: no module "${name}" in browser mode`);
+	};
+
+})();
@@ -0,0 +1,180 @@
+"use strict";
+
+// Dialogs whose answer goes back to the backend. Each function takes the "args" of the request
+// and a callback which is called exactly once with the result object.
+
+const electron = require("electron");
+const ipcMain = require("electron").ipcMain;
+const path = require("path");
+const url = require("url");
+
+let prompt_callbacks = Object.create(null);		// prompt window id --> callback
+
+function parent_window() {
+	return electron.BrowserWindow.getFocusedWindow() || undefined;
+}
+
+function show_message_box(opts, callback) {
+
+	// Older Electron takes a callback; newer returns a promise and ignores the callback.
+
+	let parent = parent_window();
+	let cb = (response) => callback(response);
+
+	let ret = parent ? electron.dialog.showMessageBox(parent, opts, cb) : electron.dialog.showMessageBox(opts, cb);
+
+	if (ret && typeof(ret.then) === "function") {
+		ret.then((r) => callback(r.response));
+	}
+}
+
+exports.message_box = (args, callback) => {
+
+	if (args.kind === "prompt") {
+		show_prompt(args, callback);
+		return;
+	}
+
+	let buttons = args.buttons && args.buttons.length > 0 ? args.buttons : ["OK"];
+
+	let opts = {
+		type: args.kind === "confirm" ? "question" : "none",
+		message: args.message,
+		title: args.kind === "confirm" ? "Confirm" : "Choose",
+		buttons: buttons,
+		defaultId: 0,
+	};
+
+	if (args.kind === "confirm") {
+		opts.cancelId = 1;
+	}
+
+	show_message_box(opts, (response) => {
+		callback({response: response});
+	});
+};
+
+// --------------------------------------------------------------------------
+
+function show_prompt(args, callback) {
+
+	let parent = parent_window();
+
+	let win = new electron.BrowserWindow({
+		parent: parent,
+		modal: parent !== undefined,
+		show: false,
+		title: "Prompt",
+		width: 400,
+		height: 150,
+		useContentSize: true,
+		resizable: false,
+		minimizable: false,
+		maximizable: false,
+		backgroundColor: "#000000",
+		webPreferences: {
+			nodeIntegration: true
+		}
+	});
+
+	win.setMenu(null);
+
+	let id = win.id;
+	let answered = false;
+
+	prompt_callbacks[id] = (result) => {
+		if (answered) {
+			return;
+		}
+		answered = true;
+		delete prompt_callbacks[id];
+		callback(result);
+	};
+
+	win.loadURL(url.format({
+		protocol: "file:",
+		pathname: path.join(__dirname, "..", "pages", "prompt.html"),
+		slashes: true
+	}));
+
+	win.webContents.on("did-finish-load", () => {
+		win.webContents.send("init", {message: args.message, default: args.default});
+		win.show();
+	});
+
+	win.on("closed", () => {
+		if (prompt_callbacks[id]) {
+			prompt_callbacks[id]({cancelled: true, text: ""});
+		}
+	});
+}
+
+ipcMain.on("prompt_result", (event, msg) => {
+
+	let win = electron.BrowserWindow.fromWebContents(event.sender);
+	if (!win) {
+		return;
+	}
+
+	let cb = prompt_callbacks[win.id];
+	if (cb) {
+		cb({cancelled: msg.cancelled === true, text: msg.text || ""});
+	}
+
+	win.close();
+});
+
+// --------------------------------------------------------------------------
+
+exports.file_dialog = (args, callback) => {
+
+	let opts = {
+		title: args.title || undefined,
+		defaultPath: args.defaultpath || undefined,
+		filters: args.filters || undefined,
+	};
+
+	let parent = parent_window();
+
+	// As with message boxes, older Electron calls back with the path(s), newer returns a promise.
+
+	let done = false;
+
+	let finish = (paths) => {
+		if (done) {
+			return;
+		}
+		done = true;
+		if (paths === undefined || paths === null || paths.length === 0) {
+			callback({cancelled: true, paths: []});
+		} else {
+			callback({cancelled: false, paths: paths});
+		}
+	};
+
+	let ret;
+
+	if (args.kind === "save") {
+
+		let cb = (filename) => finish(filename ? [filename] : undefined);
+		ret = parent ? electron.dialog.showSaveDialog(parent, opts, cb) : electron.dialog.showSaveDialog(opts, cb);
+
+		if (ret && typeof(ret.then) === "function") {
+			ret.then((r) => finish(r.canceled || !r.filePath ? undefined : [r.filePath]));
+		}
+
+	} else {
+
+		opts.properties = [args.directory ? "openDirectory" : "openFile", "createDirectory"];
+		if (args.multiple) {
+			opts.properties.push("multiSelections");
+		}
+
+		let cb = (filenames) => finish(filenames);
+		ret = parent ? electron.dialog.showOpenDialog(parent, opts, cb) : electron.dialog.showOpenDialog(opts, cb);
+
+		if (ret && typeof(ret.then) === "function") {
+			ret.then((r) => finish(r.canceled ? undefined : r.filePaths));
+		}
+	}
+};
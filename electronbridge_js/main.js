@@ -2,15 +2,23 @@
 
 const alert = require("./alert");
 const child_process = require("child_process");
+const dialogs = require("./dialogs");
 const electron = require("electron");
 const fs = require('fs');
 const ipcMain = require("electron").ipcMain;
+const menus = require("./menus");
 const readline = require("readline");
 const windows = require("./windows");
 
 const DEV_LOG_WINDOW_ID = -1;
 const TARGET_APP = "./app";
 
+// If the Go app launched us (see launch.go) we talk to it over our own stdin and stdout,
+// rather than spawning TARGET_APP ourselves.
+
+const ATTACHED = process.argv.includes("--electronbridge-attached");
+const BACKEND_NAME = ATTACHED ? "backend" : TARGET_APP;
+
 let about_message = `Electron Bridge: window manager for Golang via Electron\n` +
 					`--\n` +
 					`Electron ${process.versions.electron}\n` +
@@ -21,7 +29,20 @@ electron.app.on("ready", () => {
 	main();
 });
 
-function rebuild_menu(write_to_exe, registered_commands) {
+function menu_click_handler(write_to_exe) {
+
+	// Returns the on_click function for items of the backend's menu model.
+
+	return (id, checked) => {
+		let output = {
+			type: "cmd",
+			content: {cmd: id, checked: checked},
+		};
+		write_to_exe(JSON.stringify(output));
+	};
+}
+
+function rebuild_menu(write_to_exe, registered_commands, app_menus) {
 
 	let template = [
 		{
@@ -35,7 +56,9 @@ function rebuild_menu(write_to_exe, registered_commands) {
 					type: "separator",
 				},
 				{
-					role: "quit",
+					label: "Quit",
+					accelerator: "CmdOrCtrl+Q",
+					click: () => windows.request_quit(),
 				},
 			]
 		},
@@ -100,8 +123,21 @@ function rebuild_menu(write_to_exe, registered_commands) {
 		template[0]["submenu"].push(registered_commands[n]);
 	}
 
+	// Menus from the backend's menu model go between App and Windows...
+
+	let on_click = menu_click_handler(write_to_exe);
+
+	for (let n = 0; n < app_menus.length; n++) {
+		template.splice(1 + n, 0, {
+			label: app_menus[n].label,
+			submenu: menus.template_from_items(app_menus[n].items, on_click),
+		});
+	}
+
 	const menu = electron.Menu.buildFromTemplate(template);
 	electron.Menu.setApplicationMenu(menu);
+
+	windows.reapply_menus(on_click);
 }
 
 function main() {
@@ -120,7 +156,7 @@ function main() {
 
 	let have_warned_socket = false;
 
-	function write_to_log(sender, msg) {
+	function write_to_log(sender, msg, is_error) {
 		if (msg instanceof Error) {
 			msg = msg.toString();
 		}
@@ -142,30 +178,67 @@ function main() {
 		windows.relay("update", {
 			uid: DEV_LOG_WINDOW_ID,
 			msg: sender + ":  " + msg + "\n",
+			error: is_error === true,
 		});
 	}
 
 	// Communications with the compiled app...................................
 
-	let exe = child_process.spawn(TARGET_APP);
-
-	write_to_log("main.js", "Connected to " + TARGET_APP);
+	let exe_input;			// Where we write to the app
+	let exe_output;			// Where we read from the app
+	let exe_stderr;
+
+	if (ATTACHED) {
+		exe_input = process.stdout;
+		exe_output = process.stdin;
+		exe_stderr = null;	// The app sends its stderr lines as "stderr" messages instead.
+		write_to_log("main.js", "Attached to parent process " + process.ppid);
+	} else {
+		let exe = child_process.spawn(TARGET_APP, [], {
+			env: Object.assign({}, process.env, {ELECTRONBRIDGE_CHILD: "1"})
+		});
+		exe_input = exe.stdin;
+		exe_output = exe.stdout;
+		exe_stderr = exe.stderr;
+		write_to_log("main.js", "Connected to " + TARGET_APP);
+	}
 
 	function write_to_exe(msg) {
 		try {
-			exe.stdin.write(msg + "\n");
+			exe_input.write(msg + "\n");
 		} catch (e) {
 			write_to_log("main.js", e);
 		}
 	}
 
+	function send_response(id, result) {
+
+		// The answer to a request (see request.go) that the app is waiting on.
+
+		let output = {
+			type: "response",
+			content: {
+				id: id,
+				result: result,
+			}
+		};
+		write_to_exe(JSON.stringify(output));
+	}
+
 	let scanner = readline.createInterface({
-		input: exe.stdout,
+		input: exe_output,
 		output: undefined,
 		terminal: false
 	});
 
+	if (ATTACHED) {
+		scanner.on("close", () => {
+			electron.app.exit();			// Our parent has gone away.
+		});
+	}
+
 	let registered_commands = [];
+	let app_menus = [];
 
 	scanner.on("line", (line) => {
 		let j = JSON.parse(line);
@@ -178,14 +251,66 @@ function main() {
 			windows.relay(j.command, j.content);
 		}
 
+		if (j.command === "palette") {
+			if (j.content.uid === 0) {
+				windows.relay_all("palette", j.content);
+			} else {
+				windows.relay("palette", j.content);
+			}
+		}
+
+		if (j.command === "tileset") {
+			windows.relay_all("tileset", j.content);
+		}
+
 		if (j.command === "alert") {
 			alert(j.content);
 		}
 
+		if (j.command === "dialog") {
+			dialogs.message_box(j.content.args, (result) => send_response(j.content.id, result));
+		}
+
+		if (j.command === "filedialog") {
+			dialogs.file_dialog(j.content.args, (result) => send_response(j.content.id, result));
+		}
+
+		if (j.command === "contextmenu") {
+			windows.popup_menu(j.content.args, (index) => send_response(j.content.id, {index: index}));
+		}
+
+		if (j.command === "capture") {
+			windows.capture(j.content.args.uid, (image) => {
+				if (image === null) {
+					send_response(j.content.id, {error: "no such window"});
+				} else {
+					send_response(j.content.id, {png: image.toPNG().toString("base64")});
+				}
+			});
+		}
+
 		if (j.command === "allowquit") {
 			windows.quit_now_possible();
 		}
 
+		if (j.command === "quithandler") {
+			windows.set_quit_requester(j.content ? () => {
+				let output = {
+					type: "quitrequest",
+					content: null,
+				};
+				write_to_exe(JSON.stringify(output));
+			} : null);
+		}
+
+		if (j.command === "quitvetoed") {
+			windows.quit_vetoed();
+		}
+
+		if (j.command === "quit") {
+			electron.app.exit();
+		}
+
 		if (j.command === "register") {
 
 			let item = {
@@ -194,7 +319,7 @@ function main() {
 				click: () => {
 					let output = {
 						type: "cmd",
-						content: {cmd: j.content.label},
+						content: {cmd: j.content.id || j.content.label},
 					};
 					write_to_exe(JSON.stringify(output));
 				}
@@ -208,7 +333,22 @@ function main() {
 		}
 
 		if (j.command === "buildmenu") {
-			rebuild_menu(write_to_exe, registered_commands);
+			rebuild_menu(write_to_exe, registered_commands, app_menus);
+		}
+
+		if (j.command === "menus") {
+			app_menus = j.content || [];
+		}
+
+		if (j.command === "menustate") {
+			if (menus.apply_states(app_menus, j.content, [electron.Menu.getApplicationMenu()])) {
+				rebuild_menu(write_to_exe, registered_commands, app_menus);
+			}
+			windows.apply_menu_states(j.content, menu_click_handler(write_to_exe));
+		}
+
+		if (j.command === "windowmenu") {
+			windows.set_menu(j.content.uid, j.content.menus, menu_click_handler(write_to_exe));
 		}
 
 		if (j.command === "about") {
@@ -224,22 +364,35 @@ function main() {
 		}
 
 		if (j.command === "silentlog") {
-			write_to_log(TARGET_APP, j.content);
+			write_to_log(BACKEND_NAME, j.content);
+		}
+
+		if (j.command === "panic") {
+			write_to_log(BACKEND_NAME + " PANIC", j.content.message + "\n\n" + j.content.stack, true);
+			windows.show(DEV_LOG_WINDOW_ID);
+		}
+
+		if (j.command === "stderr") {
+			write_to_log(BACKEND_NAME, j.content);
+			windows.show(DEV_LOG_WINDOW_ID);
 		}
 	});
 
 	// Stderr messages from the compiled app...................................
 
-	let stderr_scanner = readline.createInterface({
-		input: exe.stderr,
-		output: undefined,
-		terminal: false
-	});
+	if (exe_stderr) {
 
-	stderr_scanner.on("line", (line) => {
-		write_to_log(TARGET_APP, line);
-		windows.show(DEV_LOG_WINDOW_ID);
-	});
+		let stderr_scanner = readline.createInterface({
+			input: exe_stderr,
+			output: undefined,
+			terminal: false
+		});
+
+		stderr_scanner.on("line", (line) => {
+			write_to_log(TARGET_APP, line);
+			windows.show(DEV_LOG_WINDOW_ID);
+		});
+	}
 
 	// Messages from the renderer..............................................
 
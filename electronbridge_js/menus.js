@@ -0,0 +1,103 @@
+"use strict";
+
+// Turns the menu model sent by the backend (see menu.go) into Electron menu templates,
+// and applies the state changes the backend sends afterwards.
+
+const electron = require("electron");
+
+exports.template_from_items = (items, on_click) => {
+
+	// on_click(id, checked) is called when an item is clicked.
+
+	let template = [];
+
+	for (let item of items || []) {
+
+		if (item.type === "separator") {
+			template.push({type: "separator"});
+			continue;
+		}
+
+		let t = {
+			label: item.label,
+			enabled: item.enabled,
+		};
+
+		if (item.id !== "") {
+			t.id = item.id;
+		}
+
+		if (item.type === "submenu") {
+			t.submenu = exports.template_from_items(item.submenu ? item.submenu.items : [], on_click);
+			template.push(t);
+			continue;
+		}
+
+		if (item.accelerator !== "") {
+			t.accelerator = item.accelerator;
+		}
+
+		t.type = item.type;
+
+		if (item.type === "checkbox" || item.type === "radio") {
+			t.checked = item.checked;
+		}
+
+		t.click = (menu_item) => on_click(item.id, menu_item.checked === true);
+
+		template.push(t);
+	}
+
+	return template;
+};
+
+exports.apply_states = (menus, states, live_menus) => {
+
+	// Updates the model in place. Enabled and checked are also changed on the live Electron
+	// menus where possible; returns true if a rebuild is needed anyway (e.g. for a new label).
+
+	let rebuild = false;
+
+	for (let state of states || []) {
+
+		let item = find_item(menus, state.id);
+		if (item === undefined) {
+			continue;
+		}
+
+		if (item.label !== state.label) {
+			rebuild = true;
+		}
+
+		item.label = state.label;
+		item.enabled = state.enabled;
+		item.checked = state.checked;
+
+		for (let live of live_menus) {
+			let live_item = live ? live.getMenuItemById(state.id) : null;
+			if (live_item) {
+				live_item.enabled = state.enabled;
+				live_item.checked = state.checked;
+			}
+		}
+	}
+
+	return rebuild;
+};
+
+function find_item(menus, id) {
+	for (let menu of menus || []) {
+		for (let item of menu.items || []) {
+			if (item.id === id) {
+				return item;
+			}
+			if (item.type === "submenu") {
+				let found = find_item([item.submenu], id);
+				if (found !== undefined) {
+					return found;
+				}
+			}
+		}
+	}
+	return undefined;
+}
@@ -4,15 +4,21 @@ const alert = require("./alert");
 const assert = require("assert");
 const electron = require("electron");
 const fs = require("fs");
+const menus = require("./menus");
 const path = require("path");
 const url = require("url");
 
 // The windobject is our fundamental object, containing fields:
-//			{uid, win, config, ready, queue}
+//			{uid, win, config, ready, queue, menu_model, live_menu}
+//
+// menu_model is the window's own menus from the backend (see SetWindowMenu in menu.go), or
+// null for no menu at all, or undefined if the window just uses the application menu.
 
 let windobjects = Object.create(null);		// dict: uid --> windobject
 
 let quit_possible = false;					// call quit_now_possible() to set this true and allow the module to quit the app
+let quit_requester = null;					// if set (see set_quit_requester), the backend decides whether we really quit
+let last_hidden_uid = null;
 
 exports.get_windobject_from_event = (event) => {
 	for (let uid in windobjects) {
@@ -64,7 +70,9 @@ exports.new_window = (config) => {
 		slashes: true
 	}));
 
-	if (config.nomenu === true) {
+	let menu_model = config.nomenu === true ? null : undefined;
+
+	if (menu_model === null) {
 		win.setMenu(null);
 	}
 
@@ -79,6 +87,7 @@ exports.new_window = (config) => {
 	});
 
 	win.on("hide", () => {
+		last_hidden_uid = config.uid;
 		quit_if_all_windows_are_hidden();
 	});
 
@@ -88,6 +97,8 @@ exports.new_window = (config) => {
 		config: config,
 		ready: false,
 		queue: [],
+		menu_model: menu_model,
+		live_menu: null,
 
 		send: (channel, msg) => {
 			win.webContents.send(channel, msg);
@@ -100,6 +111,12 @@ exports.relay = (channel, content) => {
 	send_or_queue(windobject, channel, content);
 };
 
+exports.relay_all = (channel, content) => {
+	for (let uid in windobjects) {
+		send_or_queue(windobjects[uid], channel, content);
+	}
+};
+
 exports.handle_ready = (windobject, opts) => {
 
 	if (windobject === undefined) {
@@ -144,14 +161,39 @@ exports.show = (uid) => {
 	}
 };
 
-exports.screenshot = (uid) => {
+exports.capture = (uid, callback) => {
+
+	// Calls back with a NativeImage of the window's contents, or null if there's no such window.
+	// Older Electron takes a callback; newer returns a promise and ignores the callback.
+
 	let windobject = windobjects[uid];
 	if (windobject === undefined) {
+		callback(null);
 		return;
 	}
 
-	let c = windobject.win.webContents;
-	c.capturePage((image) => {
+	let done = false;
+
+	let finish = (image) => {
+		if (done) {
+			return;
+		}
+		done = true;
+		callback(image);
+	};
+
+	let ret = windobject.win.webContents.capturePage(finish);
+
+	if (ret && typeof(ret.then) === "function") {
+		ret.then(finish, () => finish(null));
+	}
+};
+
+exports.screenshot = (uid) => {
+	exports.capture(uid, (image) => {
+		if (image === null) {
+			return;
+		}
 		if (fs.existsSync("screenshots") == false) {
     		fs.mkdirSync("screenshots");
 		}
@@ -160,10 +202,119 @@ exports.screenshot = (uid) => {
 	});
 };
 
+exports.popup_menu = (args, callback) => {
+
+	// Pops up a menu at grid cell (args.x, args.y) and calls back exactly once with the index
+	// of the item chosen, or -1 if the menu was dismissed (or the window doesn't exist).
+
+	let windobject = windobjects[args.uid];
+	if (windobject === undefined) {
+		callback(-1);
+		return;
+	}
+
+	let done = false;
+
+	let finish = (index) => {
+		if (done) {
+			return;
+		}
+		done = true;
+		callback(index);
+	};
+
+	let template = [];
+
+	for (let n = 0; n < args.items.length; n++) {
+		if (args.items[n] === "-") {
+			template.push({type: "separator"});
+		} else {
+			template.push({label: args.items[n], click: () => finish(n)});
+		}
+	}
+
+	let config = windobject.config;
+	let boxwidth = config.boxwidth || 1;
+	let boxheight = config.boxheight || 1;
+
+	let menu = electron.Menu.buildFromTemplate(template);
+
+	menu.popup({
+		window: windobject.win,
+		x: Math.floor(args.x * boxwidth),
+		y: Math.floor((args.y + 1) * boxheight),
+
+		// On some platforms this is called before the click handler, so give that a chance first.
+
+		callback: () => setTimeout(() => finish(-1), 100),
+	});
+};
+
+exports.set_menu = (uid, model, on_click) => {
+
+	// model is a list of menus, or null for no menu bar.
+
+	let windobject = windobjects[uid];
+	if (windobject === undefined) {
+		return;
+	}
+
+	windobject.menu_model = model;
+	apply_window_menu(windobject, on_click);
+};
+
+exports.apply_menu_states = (states, on_click) => {
+	for (let uid in windobjects) {
+		let windobject = windobjects[uid];
+		if (windobject.menu_model) {
+			if (menus.apply_states(windobject.menu_model, states, [windobject.live_menu])) {
+				apply_window_menu(windobject, on_click);
+			}
+		}
+	}
+};
+
+exports.reapply_menus = (on_click) => {
+
+	// On Windows and Linux, setting the application menu also replaces the menu of every window,
+	// so windows with their own menu (or none) need theirs putting back afterwards.
+
+	for (let uid in windobjects) {
+		if (windobjects[uid].menu_model !== undefined) {
+			apply_window_menu(windobjects[uid], on_click);
+		}
+	}
+};
+
 exports.quit_now_possible = () => {
 	quit_possible = true;
 };
 
+exports.set_quit_requester = (fn) => {
+	quit_possible = true;
+	quit_requester = fn;
+};
+
+exports.request_quit = () => {
+
+	// From the Quit menu item.
+
+	if (quit_requester) {
+		quit_requester();
+	} else {
+		electron.app.quit();
+	}
+};
+
+exports.quit_vetoed = () => {
+
+	// The backend decided not to quit. If that leaves nothing on screen, bring back the last window closed.
+
+	if (all_windows_are_hidden() && last_hidden_uid !== null) {
+		exports.show(last_hidden_uid);
+	}
+};
+
 exports.make_window_menu_items = () => {
 
 	let items = [];
@@ -217,12 +368,49 @@ function send_or_queue(windobject, channel, msg) {
 	}
 }
 
+function apply_window_menu(windobject, on_click) {
+
+	let live_menu = null;
+
+	if (windobject.menu_model) {
+		let template = windobject.menu_model.map((menu) => {
+			return {
+				label: menu.label,
+				submenu: menus.template_from_items(menu.items, on_click),
+			};
+		});
+		live_menu = electron.Menu.buildFromTemplate(template);
+	}
+
+	windobject.live_menu = live_menu;
+
+	try {
+		windobject.win.setMenu(live_menu);
+	} catch (e) {
+		// Can fail at end of app life when the window has been destroyed.
+	}
+}
+
 function quit_if_all_windows_are_hidden() {
 
 	if (!quit_possible) {
 		return;
 	}
 
+	if (!all_windows_are_hidden()) {
+		return;
+	}
+
+	if (quit_requester) {
+		quit_requester();						// The backend will tell us to quit, or not.
+		return;
+	}
+
+	electron.app.exit();						// Why doesn't quit work?
+}
+
+function all_windows_are_hidden() {
+
 	let keys = Object.keys(windobjects);
 
 	for (let n = 0; n < keys.length; n++) {
@@ -233,14 +421,14 @@ function quit_if_all_windows_are_hidden() {
 
 		try {
 			if (windobject.win.isVisible()) {
-				return;
+				return false;
 			}
 		} catch (e) {
 			// Can fail at end of app life when the window has been destroyed.
 		}
 	}
 
-	electron.app.exit();						// Why doesn't quit work?
+	return true;
 }
 
 function all_uids(positive_only) {